@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/config"
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/runner"
+	"qvl.io/httpsyet/internal/schedule"
+)
+
+const daemonUsage = `Run jobs from a config file on their schedules
+
+Usage: %s daemon [flags] -config file
+
+Each job needs a "schedule" in cron format, e.g. "0 4 1 * *" for 4am on the first day of each month.
+Also supported are macros such as "@daily" and intervals such as "@every 12h".
+Set "jitter" to a duration to randomly delay runs and "missed" to "catchup"
+to run jobs right away whose scheduled time passed while the daemon was not running.
+
+Flags:
+`
+
+// Run scheduled jobs until interrupted.
+func daemon(args []string) {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	configFile := fs.String("config", "", "JSON file defining jobs with schedules. Required.")
+	state := fs.String("state", "", "File to persist the time of the last run of each job. Required to catch up missed runs.")
+	verbose := fs.Bool("verbose", false, "Output status updates to standard error.")
+	logFormat := fs.String("log-format", "", "Write structured logs to standard error. One of text, json. Defaults to plain lines.")
+	fetch := addFetchFlags(fs)
+	cache := addCacheFlags(fs)
+	suffixList := fs.String("public-suffix-list", "", "File in the format of public_suffix_list.dat used for domain scopes. Defaults to the list installed on the system.")
+	metricsAddr := fs.String("metrics-addr", "", "If set, serve metrics in the Prometheus text format at /metrics on this address, e.g. localhost:9100.")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, daemonUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	if *configFile == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	c, err := config.Load(*configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	logger := log.New(os.Stderr, "", log.LstdFlags)
+	jobs := map[string]config.Job{}
+	var scheduled []schedule.Job
+	for _, j := range c.Jobs {
+		s, ok := j.ScheduledJob()
+		if !ok {
+			logger.Printf("job %s: no schedule, ignoring\n", j.Name)
+			continue
+		}
+		jobs[j.Name] = j
+		scheduled = append(scheduled, s)
+	}
+	if len(scheduled) == 0 {
+		fmt.Fprintln(os.Stderr, "no jobs with a schedule")
+		os.Exit(1)
+	}
+
+	r := runner.Runner{Out: os.Stdout, Err: os.Stderr, Verbose: *verbose}
+	if r.Logger, err = newLogger(*logFormat, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if r.Fetcher, err = fetch.fetcher(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if r.Cache, r.Pages, err = cache.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fetch.close()
+		os.Exit(1)
+	}
+	if *suffixList != "" {
+		if r.Suffixes, err = httpsyet.LoadPublicSuffixList(*suffixList); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			fetch.close()
+			os.Exit(1)
+		}
+	}
+	if *metricsAddr != "" {
+		r.Metrics = &metrics.Registry{}
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", r.Metrics)
+		go func() {
+			if err := http.ListenAndServe(*metricsAddr, mux); err != nil {
+				fmt.Fprintf(os.Stderr, "failed to serve metrics: %v\n", err)
+				os.Exit(1)
+			}
+		}()
+	}
+	d := &schedule.Daemon{
+		Jobs:  scheduled,
+		Log:   logger,
+		State: *state,
+		Run: func(ctx context.Context, name string) error {
+			logger.Printf("job %s: starting\n", name)
+			_, err := r.Run(ctx, jobs[name], nil)
+			if err := cache.save(r.Cache, r.Pages); err != nil {
+				logger.Printf("job %s: %v\n", name, err)
+			}
+			return err
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		cancel()
+	}()
+
+	err = d.Start(ctx)
+	fetch.close()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+)
+
+// Flags configuring the HTTP client used for crawling.
+// Shared by all commands.
+type fetchFlags struct {
+	proxy          *string
+	caFile         *string
+	cert           *string
+	key            *string
+	headers        listFlag
+	timeout        *time.Duration
+	connectTimeout *time.Duration
+	render         *string
+	renderWait     *time.Duration
+
+	chrome *httpsyet.Chrome // Started for -render.
+}
+
+func addFetchFlags(fs *flag.FlagSet) *fetchFlags {
+	f := &fetchFlags{
+		proxy:          fs.String("proxy", "", "URL of a proxy for all requests. Defaults to $HTTPS_PROXY and $HTTP_PROXY."),
+		caFile:         fs.String("ca-file", "", "PEM file with certificates to trust in addition to the system's."),
+		cert:           fs.String("cert", "", "PEM file with a client certificate. Requires -key."),
+		key:            fs.String("key", "", "PEM file with the key of the client certificate."),
+		timeout:        fs.Duration("timeout", 30*time.Second, "Limit for a whole request. Set to 0 to disable."),
+		connectTimeout: fs.Duration("connect-timeout", 10*time.Second, "Limit for establishing a connection including the TLS handshake."),
+		render:         fs.String("render", "", "Render pages with JavaScript in headless Chrome. Path of the Chrome binary or DevTools endpoint of a running browser like http://localhost:9222."),
+		renderWait:     fs.Duration("render-wait", httpsyet.DefaultRenderWait, "Time given to scripts after a rendered page loaded."),
+	}
+	fs.Var(&f.headers, "header", "Header sent with every request. Format is 'Name: value'. Can be repeated.")
+	return f
+}
+
+func (f *fetchFlags) fetcher() (httpsyet.Fetcher, error) {
+	h := http.Header{}
+	for _, s := range f.headers {
+		i := strings.Index(s, ":")
+		if i < 1 {
+			return nil, fmt.Errorf("invalid header '%s': expected format 'Name: value'", s)
+		}
+		h.Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	}
+	fetcher, err := httpsyet.NewFetcher(httpsyet.FetchOptions{
+		Proxy:          *f.proxy,
+		CAFile:         *f.caFile,
+		CertFile:       *f.cert,
+		KeyFile:        *f.key,
+		Header:         h,
+		Timeout:        *f.timeout,
+		ConnectTimeout: *f.connectTimeout,
+	})
+	if err != nil || *f.render == "" {
+		return fetcher, err
+	}
+
+	endpoint := *f.render
+	if !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+		if f.chrome, err = httpsyet.StartChrome(endpoint); err != nil {
+			return nil, err
+		}
+		endpoint = f.chrome.Endpoint
+	}
+	return httpsyet.NewRenderer(httpsyet.RenderOptions{
+		Endpoint: endpoint,
+		Fetcher:  fetcher,
+		Wait:     *f.renderWait,
+	})
+}
+
+// Stop the browser started for -render.
+func (f *fetchFlags) close() {
+	if f.chrome != nil {
+		_ = f.chrome.Close()
+	}
+}
+
+// Flags configuring the caches kept between runs.
+type cacheFlags struct {
+	file    *string
+	hostTTL *time.Duration
+	urlTTL  *time.Duration
+	pages   *string
+}
+
+func addCacheFlags(fs *flag.FlagSet) *cacheFlags {
+	return &cacheFlags{
+		file:    fs.String("probe-cache", "", "File to remember results of checking external links between runs."),
+		hostTTL: fs.Duration("probe-cache-host-ttl", httpsyet.DefaultHostTTL, "How long to remember that a host does not support HTTPS."),
+		urlTTL:  fs.Duration("probe-cache-ttl", httpsyet.DefaultURLTTL, "How long to remember the status of an external link."),
+		pages:   fs.String("page-cache", "", "File to remember ETag, Last-Modified and links of internal pages. Unchanged pages are not parsed again."),
+	}
+}
+
+// Returns nil for caches without a file.
+func (f *cacheFlags) load() (*httpsyet.ProbeCache, *httpsyet.PageStore, error) {
+	var c *httpsyet.ProbeCache
+	if *f.file != "" {
+		var err error
+		if c, err = httpsyet.LoadProbeCache(*f.file); err != nil {
+			return nil, nil, err
+		}
+		c.HostTTL = *f.hostTTL
+		c.URLTTL = *f.urlTTL
+	}
+	var p *httpsyet.PageStore
+	if *f.pages != "" {
+		var err error
+		if p, err = httpsyet.LoadPageStore(*f.pages); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c, p, nil
+}
+
+func (f *cacheFlags) save(c *httpsyet.ProbeCache, p *httpsyet.PageStore) error {
+	if c != nil {
+		if err := c.Save(*f.file); err != nil {
+			return err
+		}
+	}
+	if p != nil {
+		return p.Save(*f.pages)
+	}
+	return nil
+}
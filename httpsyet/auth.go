@@ -0,0 +1,306 @@
+package httpsyet
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Auth holds the credentials for one host.
+// They are only sent to this host, never to links pointing elsewhere.
+type Auth struct {
+	Host       string      // Required. Host as in URLs, including the port if it is not the default.
+	Username   string      // Optional. User for basic auth.
+	Password   string      // Optional. Password for basic auth.
+	Token      string      // Optional. Bearer token.
+	Header     http.Header // Optional. Custom headers like API keys.
+	CookieFile string      // Optional. Cookies in the Netscape cookies.txt format, e.g. exported from a browser.
+	Login      *FormLogin  // Optional. Login made before crawling.
+}
+
+// FormLogin submits a login form.
+// Cookies set in the response, including on redirects, are used for all further requests to the host.
+type FormLogin struct {
+	URL    string     // Required. Form action on the host of the Auth.
+	Fields url.Values // Required. Form fields like user name and password.
+}
+
+// Limit of redirects followed by NewAuthFetcher.
+const maxRedirects = 10
+
+// Context key disabling redirects in Fetchers returned by NewFetcher.
+type noRedirectKey struct{}
+
+// NewAuthFetcher returns a Fetcher adding the credentials of auths to requests made with f.
+// Requests to other hosts are passed on unchanged.
+// Redirects are followed by the returned Fetcher itself
+// so credentials are only ever sent to the configured hosts;
+// this requires f to be created by NewFetcher.
+// Other Fetchers follow redirects on their own and only the cookies of the final response are kept.
+// Cookie files are read and form logins are made right away.
+func NewAuthFetcher(ctx context.Context, f Fetcher, auths []Auth) (Fetcher, error) {
+	a := &authFetcher{f: f, auths: map[string]Auth{}, jars: map[string]http.CookieJar{}}
+	for _, auth := range auths {
+		host := strings.ToLower(auth.Host)
+		if host == "" {
+			return nil, errors.New("auth: no host given")
+		}
+		if _, ok := a.auths[host]; ok {
+			return nil, fmt.Errorf("auth: duplicate host '%s'", auth.Host)
+		}
+		jar, err := cookiejar.New(nil)
+		if err != nil {
+			return nil, err
+		}
+		a.auths[host] = auth
+		a.jars[host] = jar
+
+		if auth.CookieFile != "" {
+			cookies, err := ReadCookieFile(auth.CookieFile, hostname(host))
+			if err != nil {
+				return nil, fmt.Errorf("auth %s: %v", auth.Host, err)
+			}
+			for _, scheme := range []string{"http", "https"} {
+				jar.SetCookies(&url.URL{Scheme: scheme, Host: host, Path: "/"}, cookies)
+			}
+		}
+	}
+
+	for _, auth := range auths {
+		if auth.Login == nil {
+			continue
+		}
+		if err := a.login(ctx, auth); err != nil {
+			return nil, fmt.Errorf("auth %s: login failed: %v", auth.Host, err)
+		}
+	}
+	return a, nil
+}
+
+type authFetcher struct {
+	f     Fetcher
+	auths map[string]Auth
+	mu    sync.Mutex // Guards jars.
+	jars  map[string]http.CookieJar
+}
+
+func (a *authFetcher) Fetch(r *http.Request) (*http.Response, error) {
+	if _, ok := a.auths[strings.ToLower(r.URL.Host)]; !ok {
+		return a.f.Fetch(r)
+	}
+	return a.follow(r)
+}
+
+func (a *authFetcher) login(ctx context.Context, auth Auth) error {
+	u, err := url.Parse(auth.Login.URL)
+	if err != nil {
+		return err
+	}
+	if !strings.EqualFold(u.Host, auth.Host) {
+		return fmt.Errorf("login URL '%s' is not on host '%s'", auth.Login.URL, auth.Host)
+	}
+	body := auth.Login.Fields.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := a.follow(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("HTTP status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Make a request and follow redirects while adding credentials only for configured hosts.
+func (a *authFetcher) follow(r *http.Request) (*http.Response, error) {
+	req := r.Clone(context.WithValue(r.Context(), noRedirectKey{}, true))
+	for i := 0; ; i++ {
+		a.authorize(req)
+		resp, err := a.f.Fetch(req)
+		if err != nil {
+			return nil, err
+		}
+		a.store(resp)
+
+		loc := resp.Header.Get("Location")
+		if !isRedirect(resp.StatusCode) || loc == "" || resp.Request.URL.String() != req.URL.String() {
+			// Not a redirect or the Fetcher followed it on its own.
+			return resp, nil
+		}
+		if i == maxRedirects {
+			resp.Body.Close()
+			return nil, fmt.Errorf("stopped after %d redirects", maxRedirects)
+		}
+		next, err := req.URL.Parse(loc)
+		if err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("invalid redirect '%s': %v", loc, err)
+		}
+		resp.Body.Close()
+
+		req, err = redirect(req, next, resp.StatusCode)
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Set credentials of the request's host and remove all others.
+func (a *authFetcher) authorize(r *http.Request) {
+	r.Header.Del("Authorization")
+	r.Header.Del("Cookie")
+	for _, auth := range a.auths {
+		for k := range auth.Header {
+			r.Header.Del(k)
+		}
+	}
+
+	host := strings.ToLower(r.URL.Host)
+	auth, ok := a.auths[host]
+	if !ok {
+		return
+	}
+	if auth.Username != "" || auth.Password != "" {
+		r.SetBasicAuth(auth.Username, auth.Password)
+	}
+	if auth.Token != "" {
+		r.Header.Set("Authorization", "Bearer "+auth.Token)
+	}
+	for k, vs := range auth.Header {
+		for _, v := range vs {
+			r.Header.Add(k, v)
+		}
+	}
+	a.mu.Lock()
+	cookies := a.jars[host].Cookies(r.URL)
+	a.mu.Unlock()
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+}
+
+// Keep cookies set by configured hosts.
+func (a *authFetcher) store(resp *http.Response) {
+	u := resp.Request.URL
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if jar, ok := a.jars[strings.ToLower(u.Host)]; ok {
+		jar.SetCookies(u, resp.Cookies())
+	}
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// Returns the request following a redirect to u like http.Client does.
+func redirect(r *http.Request, u *url.URL, status int) (*http.Request, error) {
+	method := r.Method
+	var body io.ReadCloser
+	if status == http.StatusTemporaryRedirect || status == http.StatusPermanentRedirect {
+		if r.GetBody != nil {
+			b, err := r.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			body = b
+		} else if r.Body != nil && r.Body != http.NoBody {
+			return nil, errors.New("cannot repeat request body on redirect")
+		}
+	} else if method != http.MethodGet && method != http.MethodHead {
+		method = http.MethodGet
+	}
+
+	next, err := http.NewRequestWithContext(r.Context(), method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range r.Header {
+		if body == nil && (k == "Content-Type" || k == "Content-Length") {
+			continue
+		}
+		next.Header[k] = vs
+	}
+	if r.GetBody != nil && body != nil {
+		next.GetBody = r.GetBody
+		next.ContentLength = r.ContentLength
+	}
+	return next, nil
+}
+
+// ReadCookieFile reads cookies of host from a file in the Netscape cookies.txt format.
+// Cookies of other domains are ignored.
+func ReadCookieFile(file, host string) ([]*http.Cookie, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var cookies []*http.Cookie
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		httpOnly := strings.HasPrefix(line, "#HttpOnly_")
+		if httpOnly {
+			line = strings.TrimPrefix(line, "#HttpOnly_")
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Split(line, "\t")
+		if len(fields) != 7 {
+			return nil, fmt.Errorf("%s:%d: expected 7 tab separated fields", file, n)
+		}
+		domain := strings.ToLower(fields[0])
+		subdomains := strings.EqualFold(fields[1], "TRUE")
+		if !matchDomain(host, domain, subdomains) {
+			continue
+		}
+		c := &http.Cookie{
+			Name:     fields[5],
+			Value:    fields[6],
+			Path:     fields[2],
+			Secure:   strings.EqualFold(fields[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if exp, err := strconv.ParseInt(fields[4], 10, 64); err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid expiry '%s'", file, n, fields[4])
+		} else if exp > 0 {
+			c.Expires = time.Unix(exp, 0)
+		}
+		cookies = append(cookies, c)
+	}
+	return cookies, s.Err()
+}
+
+func matchDomain(host, domain string, subdomains bool) bool {
+	domain = strings.TrimPrefix(domain, ".")
+	return host == domain || (subdomains && strings.HasSuffix(host, "."+domain))
+}
+
+// Host without port.
+func hostname(host string) string {
+	return (&url.URL{Host: host}).Hostname()
+}
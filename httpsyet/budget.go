@@ -0,0 +1,126 @@
+package httpsyet
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Budget limits how much is crawled.
+// Once a budget is exhausted, requests in progress are completed
+// and all pages left in the queue are reported as unexplored.
+type Budget struct {
+	Pages    int64         // Optional. Maximum number of pages and links requested.
+	Bytes    int64         // Optional. Maximum bytes downloaded from internal pages.
+	PageSize int64         // Optional. Maximum bytes read from a single page. Larger pages are truncated.
+	Duration time.Duration // Optional. Maximum time spent crawling.
+}
+
+func (b Budget) validate() error {
+	if b.Pages < 0 || b.Bytes < 0 || b.PageSize < 0 || b.Duration < 0 {
+		return errors.New("budget cannot be negative")
+	}
+	return nil
+}
+
+// Tracks the usage of a Budget.
+// Safe for concurrent use.
+type budget struct {
+	Budget
+	site  string // Empty for the budget of all sites.
+	start time.Time
+	pages int64
+	bytes int64
+
+	once sync.Once // Exhaustion is only reported once.
+}
+
+func newBudget(b Budget, site string) *budget {
+	return &budget{Budget: b, site: site, start: time.Now()}
+}
+
+// Counts a page about to be requested.
+// Returns a description of the exhausted limit if there is no budget left.
+func (b *budget) take() (string, bool) {
+	if b == nil {
+		return "", true
+	}
+	if b.Duration > 0 && time.Since(b.start) >= b.Duration {
+		return fmt.Sprintf("budget of %v", b.Duration), false
+	}
+	if b.Bytes > 0 && atomic.LoadInt64(&b.bytes) >= b.Bytes {
+		return fmt.Sprintf("budget of %d bytes", b.Bytes), false
+	}
+	if b.Pages > 0 && atomic.AddInt64(&b.pages, 1) > b.Pages {
+		return fmt.Sprintf("budget of %d pages", b.Pages), false
+	}
+	return "", true
+}
+
+func (b *budget) addBytes(n int) {
+	if b != nil {
+		atomic.AddInt64(&b.bytes, int64(n))
+	}
+}
+
+// Returns the smallest page size limit of all budgets or 0 if there is none.
+func pageSize(budgets ...*budget) int64 {
+	var min int64
+	for _, b := range budgets {
+		if b != nil && b.PageSize > 0 && (min == 0 || b.PageSize < min) {
+			min = b.PageSize
+		}
+	}
+	return min
+}
+
+// Counts bytes read from a response body against budgets
+// and stops reading after a limit.
+type meter struct {
+	io.ReadCloser
+	budgets   []*budget
+	limit     int64 // 0 for no limit.
+	n         int64
+	truncated bool
+}
+
+func (m *meter) Read(p []byte) (int, error) {
+	if m.limit > 0 && m.n >= m.limit {
+		// Only report truncation if there actually is more content.
+		var b [1]byte
+		if n, _ := m.ReadCloser.Read(b[:]); n > 0 {
+			m.truncated = true
+		}
+		return 0, io.EOF
+	}
+	if m.limit > 0 && int64(len(p)) > m.limit-m.n {
+		p = p[:m.limit-m.n]
+	}
+	n, err := m.ReadCloser.Read(p)
+	m.n += int64(n)
+	for _, b := range m.budgets {
+		b.addBytes(n)
+	}
+	return n, err
+}
+
+// Writes lines.
+// Safe for concurrent use.
+type lineWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (lw *lineWriter) write(line string) error {
+	if lw == nil {
+		return nil
+	}
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	_, err := io.WriteString(lw.w, strings.TrimSpace(line)+"\n")
+	return err
+}
@@ -0,0 +1,183 @@
+package httpsyet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Default lifetimes of ProbeCache entries.
+const (
+	DefaultHostTTL = 30 * 24 * time.Hour
+	DefaultURLTTL  = 7 * 24 * time.Hour
+)
+
+// ProbeCache remembers the results of checking external links.
+// Hosts not reachable via HTTPS are not probed again
+// and the status of each URL is reused until it expires.
+// Of failed probes, only TLS errors, refused connections and status codes
+// which are neither server errors nor retried are remembered.
+// It is safe for concurrent use and can be shared between crawlers.
+// Use LoadProbeCache and Save to keep it between runs.
+type ProbeCache struct {
+	HostTTL time.Duration // Optional. How long HTTPS availability of a host is remembered. Defaults to DefaultHostTTL.
+	URLTTL  time.Duration // Optional. How long the status of a URL is remembered. Defaults to DefaultURLTTL.
+
+	mu    sync.Mutex
+	hosts map[string]hostProbe
+	urls  map[string]urlProbe
+}
+
+type hostProbe struct {
+	HTTPS   bool      `json:"https"`
+	Checked time.Time `json:"checked"`
+}
+
+type urlProbe struct {
+	Status   int       `json:"status,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Category string    `json:"category,omitempty"` // Of the error.
+	Checked  time.Time `json:"checked"`
+}
+
+type probeCacheFile struct {
+	Hosts map[string]hostProbe `json:"hosts"`
+	URLs  map[string]urlProbe  `json:"urls"`
+}
+
+// LoadProbeCache reads a cache written by Save.
+// Returns an empty cache if the file does not exist.
+func LoadProbeCache(file string) (*ProbeCache, error) {
+	c := &ProbeCache{}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read probe cache: %v", err)
+	}
+	var f probeCacheFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("invalid probe cache '%s': %v", file, err)
+	}
+	c.hosts = f.Hosts
+	c.urls = f.URLs
+	return c, nil
+}
+
+// Save writes all entries which are not expired to file.
+// The file is replaced atomically.
+func (c *ProbeCache) Save(file string) error {
+	c.mu.Lock()
+	f := probeCacheFile{Hosts: map[string]hostProbe{}, URLs: map[string]urlProbe{}}
+	now := time.Now()
+	for h, p := range c.hosts {
+		if now.Sub(p.Checked) < ttl(c.HostTTL, DefaultHostTTL) {
+			f.Hosts[h] = p
+		}
+	}
+	for u, p := range c.urls {
+		if now.Sub(p.Checked) < ttl(c.URLTTL, DefaultURLTTL) {
+			f.URLs[u] = p
+		}
+	}
+	c.mu.Unlock()
+
+	b, err := json.Marshal(f)
+	if err != nil {
+		return fmt.Errorf("failed to encode probe cache: %v", err)
+	}
+	if err := writeFile(file, b); err != nil {
+		return fmt.Errorf("failed to write probe cache: %v", err)
+	}
+	return nil
+}
+
+// Reports if a failed probe is worth remembering.
+// Timeouts and other temporary errors might not happen on the next run.
+func definitive(err error) bool {
+	category := ErrorCategory(err)
+	return category == CategoryTLS || category == CategoryRefused
+}
+
+// Returns false if the host is known to not support HTTPS.
+func (c *ProbeCache) maybeHTTPS(host string) bool {
+	if c == nil {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.hosts[host]
+	return !ok || p.HTTPS || time.Since(p.Checked) >= ttl(c.HostTTL, DefaultHostTTL)
+}
+
+func (c *ProbeCache) setHTTPS(host string, ok bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hosts == nil {
+		c.hosts = map[string]hostProbe{}
+	}
+	c.hosts[host] = hostProbe{HTTPS: ok, Checked: time.Now()}
+}
+
+// Returns the status or error of a previous probe.
+func (c *ProbeCache) get(u string) (urlProbe, bool) {
+	if c == nil {
+		return urlProbe{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p, ok := c.urls[u]
+	if !ok || time.Since(p.Checked) >= ttl(c.URLTTL, DefaultURLTTL) {
+		return urlProbe{}, false
+	}
+	return p, true
+}
+
+func (c *ProbeCache) set(u string, status int, err error) {
+	if c == nil {
+		return
+	}
+	p := urlProbe{Status: status, Checked: time.Now()}
+	if err != nil {
+		p.Error = err.Error()
+		p.Category = ErrorCategory(err)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.urls == nil {
+		c.urls = map[string]urlProbe{}
+	}
+	c.urls[u] = p
+}
+
+func ttl(d, def time.Duration) time.Duration {
+	if d == 0 {
+		return def
+	}
+	return d
+}
+
+// Replace a file atomically to not lose data on crashes.
+func writeFile(file string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".httpsyet")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
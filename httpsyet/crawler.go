@@ -3,14 +3,19 @@
 package httpsyet
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/net/html"
@@ -18,23 +23,138 @@ import (
 
 const defaultParallel = 10
 
+// Strategies to check external links.
+const (
+	ProbeHead = "head" // HEAD requests, falling back to GET for servers not supporting them.
+	ProbeGet  = "get"  // GET requests, only reading the start of the body.
+)
+
 // Crawler is used as configuration for Run.
 // Is validated in Run().
 type Crawler struct {
 	Sites    []string                             // At least one URL.
 	Out      io.Writer                            // Required. Writes one detected site per line.
-	Log      *log.Logger                          // Required. Errors are reported here.
+	Log      *log.Logger                          // Required unless Logger is set. Errors are reported here.
+	Logger   *slog.Logger                         // Optional. Structured logger used instead of Log.
 	Depth    int                                  // Optional. Limit depth. Set to >= 1.
 	Parallel int                                  // Optional. Set how many sites to crawl in parallel.
 	Delay    time.Duration                        // Optional. Set delay between crawls.
-	Get      func(string) (*http.Response, error) // Optional. Defaults to http.Get.
-	Verbose  bool                                 // Optional. If set, status updates are written to logger.
+	Get      func(string) (*http.Response, error) // Optional. Used if Fetcher is not set. Defaults to http.Get.
+	Fetcher  Fetcher                              // Optional. Makes all requests. Defaults to Get.
+	Verbose  bool                                 // Optional. If set, status updates are written to Log.
+	Include  []*regexp.Regexp                     // Optional. Only follow links matching at least one expression.
+	Exclude  []*regexp.Regexp                     // Optional. Never follow links matching any expression.
+	Progress *Progress                            // Optional. Updated while crawling.
+	Probe    string                               // Optional. How external links are checked: ProbeHead or ProbeGet. Defaults to ProbeHead.
+	Cache    *ProbeCache                          // Optional. Reuses results of checking external links.
+	Pages    *PageStore                           // Optional. Requests internal pages conditionally and reuses links of unchanged pages.
+	Retry    Retry                                // Optional. Repeats requests failing temporarily. Disabled by default.
+	Findings io.Writer                            // Optional. Enables checking for broken links. Writes one JSON Finding per line.
+	Robots   bool                                 // Optional. Links marked nofollow by rel attributes or robots meta tags are checked but not crawled.
+	Scope    Scope                                // Optional. Pages crawled recursively. Defaults to the host of each site.
+	Scopes   map[string]Scope                     // Optional. Scope by site as given in Sites, overriding Scope.
+	Suffixes *PublicSuffixList                    // Optional. Used for ScopeDomain, which fails without rules. Defaults to SystemPublicSuffixList.
+	Traps    Traps                                // Optional. Stops crawling URL patterns generating infinite pages. Disabled by default.
+
+	Budget     Budget    // Optional. Limits for all sites together.
+	SiteBudget Budget    // Optional. Limits for each site given in Sites.
+	Unexplored io.Writer // Optional. Pages left in the queue when a budget is exhausted are written here, one URL per line.
+
+	findings   *findingWriter
+	anchors    *anchors
+	budget     *budget
+	unexplored *lineWriter
+	traps      *traps
+}
+
+// Progress counts what a crawler did so far.
+// It is safe for concurrent use and can be read while the crawler is running.
+type Progress struct {
+	pages      int64
+	found      int64
+	errors     int64
+	queued     int64
+	active     int64
+	retries    int64
+	unexplored int64
+}
+
+// Stats is a snapshot of a crawler's progress.
+type Stats struct {
+	Pages   int64 `json:"pages"`   // Sites fetched.
+	Found   int64 `json:"found"`   // Links that can be updated.
+	Errors  int64 `json:"errors"`  // Errors reported via logger.
+	Queued  int64 `json:"queued"`  // Sites waiting to be crawled or currently crawled.
+	Active  int64 `json:"active"`  // Sites currently crawled.
+	Retries int64 `json:"retries"` // Requests repeated after failures.
+
+	Unexplored int64 `json:"unexplored"` // Sites not crawled because a budget is exhausted.
+}
+
+// Stats returns the current counts.
+func (p *Progress) Stats() Stats {
+	return Stats{
+		Pages:   atomic.LoadInt64(&p.pages),
+		Found:   atomic.LoadInt64(&p.found),
+		Errors:  atomic.LoadInt64(&p.errors),
+		Queued:  atomic.LoadInt64(&p.queued),
+		Active:  atomic.LoadInt64(&p.active),
+		Retries: atomic.LoadInt64(&p.retries),
+
+		Unexplored: atomic.LoadInt64(&p.unexplored),
+	}
+}
+
+// Counters are only increased if progress is tracked.
+func (p *Progress) addPage() {
+	if p != nil {
+		atomic.AddInt64(&p.pages, 1)
+	}
+}
+
+func (p *Progress) addFound() {
+	if p != nil {
+		atomic.AddInt64(&p.found, 1)
+	}
+}
+
+func (p *Progress) addError() {
+	if p != nil {
+		atomic.AddInt64(&p.errors, 1)
+	}
+}
+
+func (p *Progress) addRetry() {
+	if p != nil {
+		atomic.AddInt64(&p.retries, 1)
+	}
+}
+
+func (p *Progress) addUnexplored() {
+	if p != nil {
+		atomic.AddInt64(&p.unexplored, 1)
+	}
+}
+
+func (p *Progress) setQueued(n int) {
+	if p != nil {
+		atomic.StoreInt64(&p.queued, int64(n))
+	}
+}
+
+func (p *Progress) addActive(delta int64) {
+	if p != nil {
+		atomic.AddInt64(&p.active, delta)
+	}
 }
 
 type site struct {
-	URL    *url.URL
-	Parent *url.URL
-	Depth  int
+	URL      *url.URL
+	Parent   *url.URL
+	Depth    int
+	NoFollow bool       // Linked with rel="nofollow" or from a page with robots meta tag nofollow.
+	Scope    *siteScope // Of the site given to the crawler the page has been found on.
+	Budget   *budget    // Of the site given to the crawler. Nil if unlimited.
 }
 
 // Run the crawler.
@@ -44,11 +164,29 @@ type site struct {
 // Crawls sites recursively and reports all external links that can be changed to HTTPS.
 // Also reports broken links via error logger.
 func (c Crawler) Run() error {
+	return c.RunContext(context.Background())
+}
+
+// RunContext runs the crawler until all sites are crawled or ctx is canceled.
+// Requests in progress are aborted and no new ones are started after cancellation.
+// Returns the context's error if it has been canceled.
+// A Crawler can run multiple times concurrently.
+func (c Crawler) RunContext(ctx context.Context) error {
 	if err := c.validate(); err != nil {
 		return err
 	}
-	if c.Get == nil {
-		c.Get = http.Get
+	if c.Fetcher == nil {
+		if c.Get != nil {
+			c.Fetcher = GetFetcher(c.Get)
+		} else {
+			c.Fetcher = FetcherFunc(http.DefaultClient.Do)
+		}
+	}
+	if c.Logger == nil {
+		c.Logger = slog.New(NewLogHandler(c.Log, c.Verbose))
+	}
+	if c.Suffixes == nil {
+		c.Suffixes = SystemPublicSuffixList()
 	}
 	urls, err := toURLs(c.Sites, url.Parse)
 	if err != nil {
@@ -57,16 +195,39 @@ func (c Crawler) Run() error {
 
 	// Collect results via channel since it is not guarantied that the output writer works concurrent
 	results := make(chan string)
-	defer close(results)
+	written := make(chan struct{})
+	// Ensure all results are written before returning
+	defer func() {
+		close(results)
+		<-written
+	}()
 	go func() {
+		defer close(written)
 		for r := range results {
 			if _, err := fmt.Fprintln(c.Out, r); err != nil {
-				c.Log.Printf("failed to write output '%s': %v\n", r, err)
+				c.Logger.Error("failed to write output", "output", r,
+					"error", fmt.Sprintf("failed to write output '%s': %v", r, err))
 			}
 		}
 	}()
 
-	queue, sites, wait := makeQueue()
+	if c.Findings != nil {
+		c.findings = &findingWriter{w: c.Findings}
+		c.anchors = &anchors{ids: map[string]map[string]bool{}}
+	}
+
+	if c.Budget != (Budget{}) {
+		c.budget = newBudget(c.Budget, "")
+	}
+	if c.Unexplored != nil {
+		c.unexplored = &lineWriter{w: c.Unexplored}
+	}
+
+	if c.Traps != (Traps{}) {
+		c.traps = newTraps(c.Traps)
+	}
+
+	queue, sites, wait := makeQueue(c.Progress, c.admit)
 
 	wait <- len(urls)
 
@@ -76,7 +237,7 @@ func (c Crawler) Run() error {
 		go func() {
 			defer wg.Done()
 			// Pass channels to each worker
-			c.worker(sites, queue, wait, results)
+			c.worker(ctx, sites, queue, wait, results)
 		}()
 	}
 
@@ -85,12 +246,34 @@ func (c Crawler) Run() error {
 			URL:    u,
 			Parent: nil,
 			Depth:  c.Depth,
+			Scope:  newSiteScope(c.scope(u), u, c.Suffixes),
+			Budget: c.siteBudget(u),
 		}
 	}
 
+	start := time.Now()
+	for _, u := range urls {
+		c.Logger.Info("crawling site", "url", u.String(), "depth", c.Depth)
+	}
+
 	wg.Wait()
 
-	return nil
+	if c.anchors != nil {
+		for _, f := range c.anchors.missing() {
+			c.Progress.addError()
+			c.Logger.Warn("missing fragment", "url", f.URL, "parent", f.Page, "error", "missing fragment "+f.URL)
+			c.report(f)
+		}
+	}
+
+	attrs := []any{"duration", time.Since(start)}
+	if c.Progress != nil {
+		stats := c.Progress.Stats()
+		attrs = append(attrs, "pages", stats.Pages, "found", stats.Found, "errors", stats.Errors, "retries", stats.Retries, "unexplored", stats.Unexplored)
+	}
+	c.Logger.Info("crawl finished", attrs...)
+
+	return ctx.Err()
 }
 
 func (c Crawler) validate() error {
@@ -100,7 +283,7 @@ func (c Crawler) validate() error {
 	if c.Out == nil {
 		return errors.New("no output writer given")
 	}
-	if c.Log == nil {
+	if c.Log == nil && c.Logger == nil {
 		return errors.New("no error logger given")
 	}
 	if c.Depth < 0 {
@@ -109,19 +292,119 @@ func (c Crawler) validate() error {
 	if c.Parallel < 0 {
 		return errors.New("parallel cannot be negative")
 	}
+	if c.Retry.Max < 0 {
+		return errors.New("retries cannot be negative")
+	}
+	if c.Probe != "" && c.Probe != ProbeHead && c.Probe != ProbeGet {
+		return fmt.Errorf("unknown probe strategy '%s': expected %s or %s", c.Probe, ProbeHead, ProbeGet)
+	}
+	if err := c.Scope.validate(); err != nil {
+		return err
+	}
+	if err := c.Budget.validate(); err != nil {
+		return err
+	}
+	if err := c.SiteBudget.validate(); err != nil {
+		return err
+	}
+	if err := c.Traps.validate(); err != nil {
+		return err
+	}
+	for s, scope := range c.Scopes {
+		if !contains(c.Sites, s) {
+			return fmt.Errorf("scope for unknown site '%s'", s)
+		}
+		if err := scope.validate(); err != nil {
+			return fmt.Errorf("site %s: %v", s, err)
+		}
+	}
+	// Without rules, hosts like www.example.co.uk would include all of co.uk.
+	suffixes := c.Suffixes
+	if suffixes == nil {
+		suffixes = SystemPublicSuffixList()
+	}
+	if c.domainScope() && len(suffixes.rules) == 0 {
+		return fmt.Errorf("scope %s requires a public suffix list but none is loaded", ScopeDomain)
+	}
 	return nil
 }
 
-// TODO: how are we handling javascript: and so on?
-// what about URL without http:// ? Like example.com or 127.0.0.1:1234
-func isRelativeWithoutSlash(s string) bool {
-	if strings.HasPrefix(s, "#") {
+// Reports if any site uses ScopeDomain.
+func (c Crawler) domainScope() bool {
+	if c.Scope.Mode == ScopeDomain {
+		return true
+	}
+	for _, scope := range c.Scopes {
+		if scope.Mode == ScopeDomain {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the scope of a site given to the crawler.
+func (c Crawler) scope(u *url.URL) Scope {
+	for s, scope := range c.Scopes {
+		if su, err := url.Parse(s); err == nil && su.String() == u.String() {
+			return scope
+		}
+	}
+	return c.Scope
+}
+
+// Returns nil if sites have no budget.
+func (c Crawler) siteBudget(u *url.URL) *budget {
+	if c.SiteBudget == (Budget{}) {
+		return nil
+	}
+	return newBudget(c.SiteBudget, u.String())
+}
+
+// Count a page against the budgets of its site and of all sites.
+// Returns false if a budget is exhausted; the page is then reported as unexplored.
+func (c Crawler) spend(s site) bool {
+	for _, b := range []*budget{s.Budget, c.budget} {
+		limit, ok := b.take()
+		if ok {
+			continue
+		}
+		b.once.Do(func() {
+			msg := limit + " exhausted"
+			attrs := []any{"budget", limit}
+			if b.site != "" {
+				msg += " for site " + b.site
+				attrs = append(attrs, "site", b.site)
+			}
+			c.Progress.addError()
+			c.Logger.Warn("budget exhausted", append(attrs, "error", msg+"; remaining pages are not crawled")...)
+		})
+		c.Progress.addUnexplored()
+		c.Logger.Debug("unexplored", "url", s.URL.String())
+		if err := c.unexplored.write(s.URL.String()); err != nil {
+			c.Logger.Error("failed to write unexplored page", "error", fmt.Sprintf("failed to write unexplored page: %v", err))
+		}
 		return false
 	}
-	if strings.HasPrefix(s, "http://") {
+	return true
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Links with a scheme like mailto: or javascript:.
+var hasScheme = regexp.MustCompile(`^[a-zA-Z][a-zA-Z0-9+.-]*:`)
+
+func isRelativeWithoutSlash(s string) bool {
+	if strings.HasPrefix(s, "#") {
 		return false
 	}
-	if strings.HasPrefix(s, "https://") {
+	if strings.HasPrefix(s, "?") {
 		return false
 	}
 	if strings.HasPrefix(s, "/") {
@@ -133,39 +416,35 @@ func isRelativeWithoutSlash(s string) bool {
 	if strings.HasPrefix(s, "../") {
 		return false
 	}
-	if strings.HasPrefix(s, "javascript:") {
-		return false
-	}
-	if strings.HasPrefix(s, "mailto:") {
+	if hasScheme.MatchString(s) {
 		return false
 	}
 	return true
 }
 
-func ensureTrailingSlash(u *url.URL) *url.URL {
-	if len(u.Path) == 0 {
-		return u
-	}
-	parts := strings.Split(u.Path, "/")
-	if len(parts) == 0 {
-		return u
-	}
-	// No trailing slash if there is a file extension
-	if strings.Contains(parts[len(parts)-1], ".") {
-		return u
-	}
-	if u.Path[len(u.Path)-1] == '/' {
-		return u
+// Hosts like example.com, www.example.com:8080, 127.0.0.1:1234 or localhost:3000
+// at the start of a link.
+var domainLink = regexp.MustCompile(`^(?i)((?:[a-z0-9](?:[a-z0-9-]*[a-z0-9])?\.)+([a-z]{2,63})|\d{1,3}(?:\.\d{1,3}){3}|localhost)(:\d+)?(?:[/?#]|$)`)
+
+// Links without scheme that start with an IP address, a port
+// or a host ending in a public suffix are almost certainly meant to be absolute.
+// Since file names like notes.py or archive.zip end in public suffixes as well,
+// hosts need a path like example.com/page or to start with www.
+func (c Crawler) isBareDomain(s string) bool {
+	m := domainLink.FindStringSubmatch(s)
+	if m == nil {
+		return false
 	}
-	uNew, err := url.Parse(u.String())
-	if err != nil {
-		panic(err)
+	// A port or an IP address.
+	if m[3] != "" || m[2] == "" {
+		return true
 	}
-	uNew.Path = uNew.Path + "/"
-	return uNew
+	host := strings.ToLower(m[1])
+	return c.Suffixes.isSuffix(strings.ToLower(m[2])) && (len(s) > len(host) || strings.HasPrefix(host, "www."))
 }
 
 // Returns a list of only valid URLs.
+// Fragments are kept.
 // Invalid protocols such as mailto or javascript are ignored.
 // The returned error shows all invalid URLs in one message.
 func toURLs(links []string, parse func(string) (*url.URL, error)) (urls []*url.URL, err error) {
@@ -179,8 +458,6 @@ func toURLs(links []string, parse func(string) (*url.URL, error)) (urls []*url.U
 			invalids = append(invalids, fmt.Sprintf("%s (%v)", s, e))
 			continue
 		}
-		// Remove #hash
-		u.Fragment = ""
 		// Default to https
 		if u.Scheme == "" {
 			u.Scheme = "https"
@@ -205,17 +482,20 @@ func parallel(p int) int {
 
 // Track visited sites via channel to prevent conflicts
 // and ensure each site is visited only once.
+// Sites not visited yet are only passed on if admit returns true.
 // All channels are closed automatically as soon as queue is empty.
-func makeQueue() (chan<- site, <-chan site, chan<- int) {
+// The queue length is reported to the optional progress.
+func makeQueue(p *Progress, admit func(site) bool) (chan<- site, <-chan site, chan<- int) {
 	queueCount := 0
 	wait := make(chan int)
 	sites := make(chan site)
 	queue := make(chan site)
-	visited := map[string]struct{}{}
+	visited := map[string]site{}
 
 	go func() {
 		for delta := range wait {
 			queueCount += delta
+			p.setQueued(queueCount)
 			if queueCount == 0 {
 				close(queue)
 			}
@@ -225,8 +505,12 @@ func makeQueue() (chan<- site, <-chan site, chan<- int) {
 	go func() {
 		for s := range queue {
 			u := s.URL.String()
-			if _, v := visited[u]; !v {
-				visited[u] = struct{}{}
+			if v, ok := visited[u]; ok && !followedFurther(s, v) {
+				wait <- -1
+				continue
+			}
+			visited[u] = s
+			if admit(s) {
 				sites <- s
 			} else {
 				wait <- -1
@@ -239,109 +523,506 @@ func makeQueue() (chan<- site, <-chan site, chan<- int) {
 	return queue, sites, wait
 }
 
+// Reports if an internal page only seen via nofollow links before
+// is now linked normally and can be crawled deeper.
+// Otherwise nofollow links would hide pages reachable through other links.
+func followedFurther(s, visited site) bool {
+	if !visited.NoFollow || s.NoFollow || !s.Scope.contains(s.URL) {
+		return false
+	}
+	// Depths below 1 are unlimited.
+	if visited.Depth < 1 {
+		return false
+	}
+	return s.Depth < 1 || s.Depth > visited.Depth
+}
+
 func (c Crawler) worker(
+	ctx context.Context,
 	sites <-chan site,
 	queue chan<- site,
 	wait chan<- int,
 	results chan<- string,
 ) {
 	for s := range sites {
-		if c.Verbose {
-			c.Log.Printf("verbose: GET %s\n", s.URL)
+		// Drain the queue without crawling once canceled or out of budget.
+		if ctx.Err() != nil || !c.spend(s) {
+			wait <- -1
+			continue
 		}
 
-		links, shouldUpdate, err := crawlSite(s, c.Get)
+		// crawlSite might change the scheme of the URL.
+		link := s.URL.String()
+		attrs := []any{"url", link}
+		if s.Parent != nil {
+			attrs = append(attrs, "parent", s.Parent.String())
+		}
+		if s.NoFollow {
+			attrs = append(attrs, "nofollow", true)
+		}
 
-		if err != nil {
-			parent := ""
+		c.Progress.addActive(1)
+		start := time.Now()
+		p := c.crawlSite(ctx, s)
+		duration := time.Since(start)
+		c.Progress.addActive(-1)
+		// Requests aborted by cancellation are not broken links.
+		if ctx.Err() != nil {
+			wait <- -1
+			continue
+		}
+		c.Progress.addPage()
+
+		if p.status != 0 {
+			attrs = append(attrs, "status", p.status)
+		}
+		attrs = append(attrs, "duration", duration, "attempts", p.attempts)
+		if p.base != "" {
+			attrs = append(attrs, "base", p.base)
+		}
+		if p.robots != "" {
+			attrs = append(attrs, "robots", p.robots)
+		}
+		c.Logger.Debug("GET", attrs...)
+
+		if p.err != nil {
+			c.Progress.addError()
+			c.Logger.Warn("broken link", append(attrs, "error", p.err.Error(), "category", p.category)...)
+			f := Finding{Category: p.category, URL: link, Status: p.status, Error: p.err.Error(), NoFollow: s.NoFollow, Attempts: p.attempts}
 			if s.Parent != nil {
-				parent = fmt.Sprintf(" on page %v", s.Parent)
+				f.Page = s.Parent.String()
 			}
-			c.Log.Printf("%v%s\n", err, parent)
+			c.report(f)
+		}
+		if p.truncated > 0 {
+			c.Progress.addError()
+			c.Logger.Warn("page truncated", "url", link, "error", fmt.Sprintf("page %s truncated after %d bytes", link, p.truncated))
+		}
+		if c.anchors != nil && p.ids != nil {
+			c.anchors.addPage(s.URL.String(), p.ids)
 		}
 
-		if shouldUpdate {
+		if p.upgrade {
+			c.Progress.addFound()
 			s.URL.Scheme = "http"
 			results <- fmt.Sprintf("%v %v", s.Parent, s.URL.String())
 		}
 
-		// Ensure we can resolve relative paths properly
-		urlWithTrailingSlash := ensureTrailingSlash(s.URL)
+		// Relative links are resolved against the URL the page has been served from
+		// or the base URL it sets, as defined by RFC 3986.
+		base := s.URL
+		if p.url != nil {
+			base = p.url
+		}
+		if b, ok := c.base(base, p.base); ok {
+			base = b
+		}
 
-		urls, err := toURLs(links, urlWithTrailingSlash.Parse)
-		if err != nil {
-			c.Log.Printf("page %v: %v\n", s.URL, err)
+		// Links marked nofollow are only checked if the robots policy is enabled.
+		var follow, nofollow []string
+		for _, l := range c.checkLinks(s.URL, p.links) {
+			if p.isNoFollow(l) {
+				nofollow = append(nofollow, l)
+			} else {
+				follow = append(follow, l)
+			}
 		}
+		urls := c.resolve(s.URL, follow, base.Parse)
+		nofollowURLs := c.resolve(s.URL, nofollow, base.Parse)
 
-		wait <- len(urls) - 1
+		wait <- len(urls) + len(nofollowURLs) - 1
 
+		// Pages reached via nofollow links are fetched but not parsed.
+		nofollowDepth := s.Depth - 1
+		if c.Robots {
+			nofollowDepth = 1
+		}
 		// Submit links to queue in goroutine to not block workers
-		go queueURLs(queue, urls, s.URL, s.Depth-1)
+		go func(parent site, depth int) {
+			queueURLs(queue, urls, parent, depth, false)
+			queueURLs(queue, nofollowURLs, parent, nofollowDepth, true)
+		}(s, s.Depth-1)
+
+		select {
+		case <-time.After(c.Delay):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// Returns the URL set by <base href> of a page.
+// Invalid base URLs are reported and ignored.
+func (c Crawler) base(page *url.URL, href string) (*url.URL, bool) {
+	if href == "" {
+		return nil, false
+	}
+	u, err := page.Parse(href)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		c.Progress.addError()
+		msg := fmt.Sprintf("invalid base URL '%s'", href)
+		c.Logger.Warn("invalid base URL", "url", href, "parent", page.String(), "error", msg)
+		return nil, false
+	}
+	return u, true
+}
+
+// Resolve links of a page, strip their fragments and filter them.
+func (c Crawler) resolve(page *url.URL, links []string, parse func(string) (*url.URL, error)) []*url.URL {
+	urls, err := toURLs(links, parse)
+	if err != nil {
+		c.Progress.addError()
+		c.Logger.Warn("invalid links", "page", page.String(), "error", err.Error())
+	}
+	for _, u := range urls {
+		c.anchor(page, u)
+	}
+	return c.filter(urls)
+}
+
+// When looking for broken links, links without scheme which start with a domain are reported
+// and still resolved as relative links like browsers do.
+// Protocol-relative links on http pages are also reported since they are loaded via http.
+func (c Crawler) checkLinks(page *url.URL, links []string) []string {
+	var checked []string
+	for _, l := range links {
+		l = strings.TrimSpace(l)
+		if c.findings != nil && c.isBareDomain(l) {
+			c.Progress.addError()
+			msg := fmt.Sprintf("bare domain link '%s'", l)
+			c.Logger.Warn("bare domain link", "url", l, "parent", page.String(), "error", msg)
+			c.report(Finding{Category: CategoryBareDomain, URL: l, Page: page.String(), Error: msg})
+		}
+		if c.findings != nil && page.Scheme == "http" && strings.HasPrefix(l, "//") {
+			if u, err := page.Parse(l); err == nil {
+				msg := fmt.Sprintf("protocol-relative link '%s' is loaded via http", l)
+				c.Logger.Warn("protocol-relative link", "url", u.String(), "parent", page.String(), "error", msg)
+				c.report(Finding{Category: CategoryProtocolRelative, URL: u.String(), Page: page.String(), Error: msg})
+			}
+		}
+		checked = append(checked, l)
+	}
+	return checked
+}
+
+// Write a finding to the optional writer.
+func (c Crawler) report(f Finding) {
+	if err := c.findings.write(f); err != nil {
+		c.Logger.Error("failed to write finding", "error", fmt.Sprintf("failed to write finding: %v", err))
+	}
+}
+
+// Remove the fragment of a link and remember it to check if it exists on the target page.
+// Fragments used for routing by single page applications are ignored.
+func (c Crawler) anchor(page, u *url.URL) {
+	fragment := u.Fragment
+	u.Fragment = ""
+	u.RawFragment = ""
+	if c.anchors == nil || fragment == "" || fragment == "top" || strings.HasPrefix(fragment, "!") || strings.HasPrefix(fragment, "/") {
+		return
+	}
+	c.anchors.addLink(page.String(), u.String(), fragment)
+}
+
+// Remove URLs not matching the include and exclude rules.
+func (c Crawler) filter(urls []*url.URL) []*url.URL {
+	if len(c.Include) == 0 && len(c.Exclude) == 0 {
+		return urls
+	}
+	var filtered []*url.URL
+	for _, u := range urls {
+		if matchAny(c.Exclude, u.String()) {
+			continue
+		}
+		if len(c.Include) > 0 && !matchAny(c.Include, u.String()) {
+			continue
+		}
+		filtered = append(filtered, u)
+	}
+	return filtered
+}
 
-		time.Sleep(c.Delay)
+func matchAny(res []*regexp.Regexp, s string) bool {
+	for _, re := range res {
+		if re.MatchString(s) {
+			return true
+		}
 	}
+	return false
+}
+
+// What crawling a single site found out.
+type page struct {
+	links     []string        // Links to crawl next.
+	upgrade   bool            // The site can be updated to HTTPS.
+	status    int             // Status code of the last response, 0 if there was none.
+	attempts  int             // Requests made, including retries.
+	err       error           // Set for broken links.
+	category  string          // Category of err.
+	ids       map[string]bool // Anchors of parsed HTML pages which have not been truncated.
+	url       *url.URL        // URL of the page after redirects.
+	base      string          // Base URL of relative links if the page sets one.
+	robots    string          // Content of the robots meta tag.
+	nofollow  map[string]bool // Links with rel="nofollow".
+	truncated int64           // Size limit if the page has been truncated.
+}
+
+func (p *page) setDocument(d document) {
+	p.links, p.ids = d.links, d.ids
+	p.base, p.robots, p.nofollow = d.base, d.robots, d.nofollow
 }
 
-func crawlSite(s site, get func(string) (*http.Response, error)) ([]string, bool, error) {
+// Reports if links on the page should not be followed.
+// Applies to all links if the robots meta tag says so.
+func (p page) isNoFollow(link string) bool {
+	return p.nofollow[link] || hasToken(p.robots, "nofollow") || hasToken(p.robots, "none")
+}
+
+func (c Crawler) crawlSite(ctx context.Context, s site) page {
+	var p page
 	u := s.URL
-	isExternal := s.Parent != nil && s.URL.Host != s.Parent.Host
+	isExternal := s.Parent != nil && !s.Scope.contains(s.URL)
 
 	// If an external link is http we try https.
 	// If it fails it is ignored and we carry on normally.
 	// On success we return it as a result.
-	if isExternal && u.Scheme == "http" {
+	if isExternal && u.Scheme == "http" && c.Cache.maybeHTTPS(u.Host) {
 		u.Scheme = "https"
-		r2, err := get(u.String())
-		if err == nil {
-			defer r2.Body.Close()
-			if r2.StatusCode < 400 {
-				return nil, true, nil
-			}
+		status, err := c.check(ctx, u.String(), &p.attempts)
+		if err == nil || definitive(err) {
+			c.Cache.setHTTPS(u.Host, err == nil)
+		}
+		if err == nil && status < 400 {
+			p.upgrade = true
+			p.status = status
+			return p
 		}
 		u.Scheme = "http"
 	}
 
-	r, err := get(u.String())
+	// External pages are never parsed.
+	if isExternal {
+		status, err := c.check(ctx, u.String(), &p.attempts)
+		p.status = status
+		if err != nil {
+			p.err = fmt.Errorf("failed to get %v: %v", u, err)
+			p.category = ErrorCategory(err)
+		} else if status >= 400 {
+			p.err = fmt.Errorf("%d %v", status, u)
+			p.category = StatusCategory(status)
+		}
+		return p
+	}
+
+	rendered := &renderedRequests{}
+	req, err := newRequest(context.WithValue(ctx, renderedKey{}, rendered), http.MethodGet, u.String())
+	if err != nil {
+		p.err = fmt.Errorf("failed to get %v: %v", u, err)
+		p.category = CategoryOther
+		return p
+	}
+	c.Pages.prepare(req)
+	r, err := c.do(ctx, req, &p.attempts)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to get %v: %v", u, err)
+		p.err = fmt.Errorf("failed to get %v: %v", u, err)
+		p.category = ErrorCategory(err)
+		return p
+	}
+	m := &meter{ReadCloser: r.Body, budgets: []*budget{s.Budget, c.budget}, limit: pageSize(s.Budget, c.budget)}
+	r.Body = m
+	defer discard(r)
+	p.status = r.StatusCode
+	if r.Request != nil {
+		p.url = r.Request.URL
 	}
-	defer r.Body.Close()
 
 	if r.StatusCode >= 400 {
-		return nil, false, fmt.Errorf("%d %v", r.StatusCode, u)
+		p.err = fmt.Errorf("%d %v", r.StatusCode, u)
+		p.category = StatusCategory(r.StatusCode)
+		return p
+	}
+
+	// The page did not change since the last crawl.
+	if r.StatusCode == http.StatusNotModified {
+		if s.Depth != 1 {
+			final := u
+			if p.url != nil {
+				final = p.url
+			}
+			p.setDocument(c.Pages.document(final.String()))
+		}
+		return p
 	}
 
-	// Stop when redirecting to external page
-	if r.Request.URL.Host != u.Host {
+	// Stop when redirecting to external page.
+	// Sites given to the crawler are parsed even outside their path prefix.
+	if !s.Scope.contains(r.Request.URL) && (s.Parent != nil || r.Request.URL.Host != u.Host) {
 		isExternal = true
 	}
 
 	// Stop when site is external.
 	// Also stop if depth one is reached, ignored when depth is set to 0.
 	if isExternal || s.Depth == 1 {
-		return nil, false, err
+		return p
+	}
+
+	// Binary content has no links and is not downloaded.
+	extract, body := extractor(r)
+	if extract == nil {
+		return p
+	}
+	d, err := extract(body)
+	// Truncated content is expected to be invalid.
+	// The link itself works, so it is not reported as broken.
+	if err != nil && !m.truncated {
+		c.Progress.addError()
+		c.Logger.Warn("invalid page", "url", u.String(), "error", fmt.Sprintf("%v on page %v", err, u))
+		return p
+	}
+	// Requests made by pages rendered in a browser.
+	d.links = append(d.links, rendered.urls...)
+	if m.truncated {
+		p.truncated = m.limit
+	} else {
+		c.Pages.set(u.String(), r, d)
+	}
+	p.setDocument(d)
+	// Anchors after the limit are missing.
+	if m.truncated {
+		p.ids = nil
+	}
+	// Only reported when looking for broken links.
+	if c.Findings != nil && isNotFound(d.title) {
+		p.err = fmt.Errorf("soft 404 %v: %s", u, d.title)
+		p.category = CategorySoft404
+	}
+	return p
+}
+
+// Returns the status of an external link.
+// Results are reused if a cache is set.
+func (c Crawler) check(ctx context.Context, u string, attempts *int) (int, error) {
+	if p, ok := c.Cache.get(u); ok {
+		if p.Error != "" {
+			return 0, probeError{msg: p.Error, category: p.Category}
+		}
+		return p.Status, nil
+	}
+	r, err := c.probe(ctx, u, attempts)
+	if err != nil {
+		if definitive(err) {
+			c.Cache.set(u, 0, err)
+		}
+		return 0, err
+	}
+	discard(r)
+	// Server errors and rate limits might be gone on the next run.
+	if r.StatusCode < 500 && !c.Retry.retryableStatus(r.StatusCode) {
+		c.Cache.set(u, r.StatusCode, nil)
+	}
+	return r.StatusCode, nil
+}
+
+// Check the status of an external link without downloading its content.
+// With ProbeHead, servers not supporting HEAD are asked again using GET.
+func (c Crawler) probe(ctx context.Context, u string, attempts *int) (*http.Response, error) {
+	ctx = context.WithValue(ctx, probeKey{}, true)
+	method := http.MethodHead
+	if c.Probe == ProbeGet {
+		method = http.MethodGet
+	}
+	req, err := newRequest(ctx, method, u)
+	if err != nil {
+		return nil, err
+	}
+	r, err := c.do(ctx, req, attempts)
+	if method == http.MethodGet || (err == nil && !headUnsupported[r.StatusCode]) {
+		return r, err
+	}
+	// Some servers close the connection instead of answering HEAD requests.
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, err
 	}
+	if r != nil {
+		discard(r)
+	}
+	req, err = newRequest(ctx, http.MethodGet, u)
+	if err != nil {
+		return nil, err
+	}
+	return c.do(ctx, req, attempts)
+}
 
-	links, err := getLinks(r.Body)
-	return links, false, err
+// Status codes of servers that don't handle HEAD requests properly.
+var headUnsupported = map[int]bool{
+	http.StatusBadRequest:       true,
+	http.StatusMethodNotAllowed: true,
+	http.StatusNotImplemented:   true,
 }
 
-func getLinks(r io.Reader) ([]string, error) {
-	var links []string
+// Limit of body bytes read before closing a response.
+// Reading small bodies allows reusing the connection.
+const maxDiscard = 4 << 10
+
+// Close a response without reading more than maxDiscard bytes.
+func discard(r *http.Response) {
+	_, _ = io.CopyN(ioutil.Discard, r.Body, maxDiscard)
+	r.Body.Close()
+}
+
+// Requests are aborted when ctx is canceled.
+func newRequest(ctx context.Context, method, u string) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, method, u, nil)
+}
+
+// Content of an HTML page relevant for crawling.
+type document struct {
+	links    []string
+	ids      map[string]bool // Values of id attributes and names of anchors. Nil for documents other than HTML.
+	title    string          // Title or, if missing, the first heading.
+	base     string          // Value of <base href>. Relative links are resolved against it.
+	robots   string          // Content of <meta name="robots">, e.g. "noindex,nofollow".
+	nofollow map[string]bool // Links with rel="nofollow".
+}
+
+func getLinks(r io.Reader) (document, error) {
+	d := document{ids: map[string]bool{}}
 
 	doc, err := html.Parse(r)
 	if err != nil {
-		return links, fmt.Errorf("failed to parse html: %v", err)
+		return d, fmt.Errorf("failed to parse html: %v", err)
 	}
 
+	var heading string
 	var f func(n *html.Node)
 	f = func(n *html.Node) {
-		if n.Type == html.ElementNode && n.Data == "a" {
+		if n.Type == html.ElementNode {
 			for _, a := range n.Attr {
-				if a.Key == "href" {
-					links = append(links, a.Val)
+				if a.Key == "id" || (n.Data == "a" && a.Key == "name") {
+					d.ids[a.Val] = true
+				}
+			}
+			switch {
+			case n.Data == "a":
+				href, ok := attr(n, "href")
+				if !ok {
 					break
 				}
+				d.links = append(d.links, href)
+				if hasToken(attrValue(n, "rel"), "nofollow") {
+					if d.nofollow == nil {
+						d.nofollow = map[string]bool{}
+					}
+					d.nofollow[strings.TrimSpace(href)] = true
+				}
+			// Only the first base element counts.
+			case n.Data == "base" && d.base == "":
+				d.base = strings.TrimSpace(attrValue(n, "href"))
+			case n.Data == "meta" && strings.EqualFold(attrValue(n, "name"), "robots"):
+				d.robots = strings.ToLower(strings.Join(strings.Fields(attrValue(n, "content")), ""))
+			case n.Data == "title" && d.title == "":
+				d.title = strings.TrimSpace(text(n))
+			case n.Data == "h1" && heading == "":
+				heading = strings.TrimSpace(text(n))
 			}
 		}
 		for c := n.FirstChild; c != nil; c = c.NextSibling {
@@ -350,15 +1031,58 @@ func getLinks(r io.Reader) ([]string, error) {
 	}
 	f(doc)
 
-	return links, nil
+	if d.title == "" {
+		d.title = heading
+	}
+	return d, nil
+}
+
+// Returns the value of an attribute and if it is set.
+func attr(n *html.Node, key string) (string, bool) {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val, true
+		}
+	}
+	return "", false
+}
+
+func attrValue(n *html.Node, key string) string {
+	v, _ := attr(n, key)
+	return v
 }
 
-func queueURLs(queue chan<- site, urls []*url.URL, parent *url.URL, depth int) {
+// Reports if a list separated by spaces or commas like rel or meta robots contains token.
+func hasToken(list, token string) bool {
+	for _, t := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' || r == '\n' }) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Text content of a node.
+func text(n *html.Node) string {
+	if n.Type == html.TextNode {
+		return n.Data
+	}
+	var b strings.Builder
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		b.WriteString(text(c))
+	}
+	return b.String()
+}
+
+func queueURLs(queue chan<- site, urls []*url.URL, parent site, depth int, nofollow bool) {
 	for _, u := range urls {
 		queue <- site{
-			URL:    u,
-			Parent: parent,
-			Depth:  depth,
+			URL:      u,
+			Parent:   parent.URL,
+			Depth:    depth,
+			NoFollow: nofollow,
+			Scope:    parent.Scope,
+			Budget:   parent.Budget,
 		}
 	}
 }
@@ -1,16 +1,33 @@
 package httpsyet_test
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"qvl.io/httpsyet/httpsyet"
 )
@@ -429,6 +446,1375 @@ verbose: GET %s/sub/
 	}
 }
 
+func TestIncludeExclude(t *testing.T) {
+	visited := map[string]int{}
+	serve := func(name, html string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			visited[name]++
+			_, err := w.Write([]byte(head + html + foot))
+			noErr(t, err)
+		}
+	}
+
+	externalServer := httptest.NewServer(serve("external", basic))
+	defer externalServer.Close()
+
+	pageMux := http.NewServeMux()
+	pageMux.HandleFunc("/base", serve("base", `
+<a href="/public">public</a>
+<a href="/private/secret">private</a>
+<a href="`+externalServer.URL+`/page">external</a>
+`))
+	pageMux.HandleFunc("/public", serve("public", basic))
+	pageMux.HandleFunc("/private/secret", serve("private", basic))
+	pageServer := httptest.NewServer(pageMux)
+	defer pageServer.Close()
+
+	var out, errs bytes.Buffer
+	err := httpsyet.Crawler{
+		Out:     &out,
+		Log:     log.New(&errs, "", 0),
+		Sites:   []string{pageServer.URL + "/base"},
+		Include: []*regexp.Regexp{regexp.MustCompile("^" + regexp.QuoteMeta(pageServer.URL))},
+		Exclude: []*regexp.Regexp{regexp.MustCompile("/private/")},
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "", errs.String(), "unexpected errors")
+
+	expect := map[string]int{"base": 1, "public": 1}
+	if fmt.Sprint(visited) != fmt.Sprint(expect) {
+		t.Errorf("expected visits %v; got %v", expect, visited)
+	}
+}
+
+func TestLogger(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/base", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(head + `<a href="/404">broken</a>` + foot))
+		noErr(t, err)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var logs bytes.Buffer
+	err := httpsyet.Crawler{
+		Out:    ioutil.Discard,
+		Logger: slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Sites:  []string{server.URL + "/base"},
+	}.Run()
+	noErr(t, err)
+
+	type record struct {
+		Level  string
+		Msg    string
+		URL    string
+		Parent string
+		Status int
+		Error  string
+	}
+	var records []record
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var r record
+		noErr(t, dec.Decode(&r))
+		records = append(records, r)
+	}
+
+	var got []string
+	for _, r := range records {
+		got = append(got, fmt.Sprintf("%s %s %s %s %d %s", r.Level, r.Msg, r.URL, r.Parent, r.Status, r.Error))
+	}
+	eqLines(t, fmt.Sprintf(`INFO crawling site %[1]s/base  0 
+DEBUG GET %[1]s/base  200 
+DEBUG GET %[1]s/404 %[1]s/base 404 
+WARN broken link %[1]s/404 %[1]s/base 404 404 %[1]s/404
+INFO crawl finished   0 `, server.URL), strings.Join(got, "\n"), "unexpected records")
+}
+
+func TestFetcher(t *testing.T) {
+	var headers []string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = append(headers, r.Header.Get("X-Token"))
+		_, err := w.Write([]byte(head + `<a href="/sub">sub</a>` + foot))
+		noErr(t, err)
+	}))
+	defer server.Close()
+
+	ca, err := ioutil.TempFile("", "httpsyet-ca")
+	noErr(t, err)
+	defer os.Remove(ca.Name())
+	noErr(t, pem.Encode(ca, &pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}))
+	noErr(t, ca.Close())
+
+	f, err := httpsyet.NewFetcher(httpsyet.FetchOptions{
+		CAFile: ca.Name(),
+		Header: http.Header{"X-Token": {"secret"}},
+	})
+	noErr(t, err)
+
+	var errs bytes.Buffer
+	err = httpsyet.Crawler{
+		Out:     ioutil.Discard,
+		Log:     log.New(&errs, "", 0),
+		Sites:   []string{server.URL},
+		Fetcher: f,
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "", errs.String(), "unexpected errors")
+	if fmt.Sprint(headers) != "[secret secret]" {
+		t.Errorf("expected header with every request; got %v", headers)
+	}
+
+	_, err = httpsyet.NewFetcher(httpsyet.FetchOptions{CertFile: ca.Name()})
+	doErr(t, "client certificate requires both a cert and a key file", err)
+}
+
+func TestAuth(t *testing.T) {
+	var mu sync.Mutex
+	var leaked []string
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if h := r.Header.Get("Authorization") + r.Header.Get("Cookie") + r.Header.Get("X-Key"); h != "" {
+			mu.Lock()
+			leaked = append(leaked, h)
+			mu.Unlock()
+		}
+	}))
+	defer external.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.FormValue("user") != "ci" {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "s1", Path: "/"})
+		http.Redirect(w, r, "/", http.StatusFound)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		user, pass, _ := r.BasicAuth()
+		session, _ := r.Cookie("session")
+		pref, _ := r.Cookie("pref")
+		if user != "u" || pass != "p" || session == nil || pref == nil || r.Header.Get("X-Key") != "k" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		links := `<a href="` + external.URL + `/page">external</a>`
+		if r.URL.Path == "/" {
+			links += `<a href="/redirect">redirect</a>`
+		}
+		_, err := w.Write([]byte(head + links + foot))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, external.URL+"/redirected", http.StatusFound)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	host := strings.TrimPrefix(server.URL, "http://")
+
+	cookies, err := ioutil.TempFile("", "httpsyet-cookies")
+	noErr(t, err)
+	defer os.Remove(cookies.Name())
+	_, err = fmt.Fprintf(cookies, "# Netscape HTTP Cookie File\n127.0.0.1\tFALSE\t/\tFALSE\t0\tpref\tdark\nother.com\tTRUE\t/\tFALSE\t0\tx\ty\n")
+	noErr(t, err)
+	noErr(t, cookies.Close())
+
+	base, err := httpsyet.NewFetcher(httpsyet.FetchOptions{})
+	noErr(t, err)
+	f, err := httpsyet.NewAuthFetcher(context.Background(), base, []httpsyet.Auth{{
+		Host:       host,
+		Username:   "u",
+		Password:   "p",
+		Header:     http.Header{"X-Key": {"k"}},
+		CookieFile: cookies.Name(),
+		Login:      &httpsyet.FormLogin{URL: server.URL + "/login", Fields: url.Values{"user": {"ci"}}},
+	}})
+	noErr(t, err)
+
+	var errs bytes.Buffer
+	err = httpsyet.Crawler{
+		Out:     ioutil.Discard,
+		Log:     log.New(&errs, "", 0),
+		Sites:   []string{server.URL},
+		Fetcher: f,
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "", errs.String(), "unexpected errors")
+	if len(leaked) > 0 {
+		t.Errorf("credentials sent to external host: %v", leaked)
+	}
+
+	_, err = httpsyet.NewAuthFetcher(context.Background(), base, []httpsyet.Auth{{
+		Host:  host,
+		Login: &httpsyet.FormLogin{URL: server.URL + "/login", Fields: url.Values{"user": {"bot"}}},
+	}})
+	doErr(t, "auth "+host+": login failed: HTTP status code 403", err)
+}
+
+func TestProbe(t *testing.T) {
+	for _, probe := range []string{"", httpsyet.ProbeGet} {
+		t.Run(probe, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.Method+" "+r.URL.Path)
+				mu.Unlock()
+				if r.URL.Path == "/no-head" && r.Method == http.MethodHead {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+				if r.URL.Path == "/missing" {
+					http.NotFound(w, r)
+				}
+			}))
+			defer external.Close()
+
+			page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := w.Write([]byte(head + `
+<a href="` + external.URL + `/head">head</a>
+<a href="` + external.URL + `/no-head">no head</a>
+<a href="` + external.URL + `/missing">missing</a>
+` + foot))
+				noErr(t, err)
+			}))
+			defer page.Close()
+
+			var errs bytes.Buffer
+			err := httpsyet.Crawler{
+				Out:   ioutil.Discard,
+				Log:   log.New(&errs, "", 0),
+				Sites: []string{page.URL},
+				Probe: probe,
+			}.Run()
+			noErr(t, err)
+			eqLines(t, fmt.Sprintf("404 %s/missing on page %s", external.URL, page.URL), strings.TrimSpace(errs.String()), "unexpected errors")
+
+			expect := "GET /head\nGET /missing\nGET /no-head"
+			if probe == "" {
+				expect = "HEAD /head\nHEAD /missing\nHEAD /no-head\nGET /no-head"
+			}
+			eqLines(t, expect, strings.Join(requests, "\n"), "unexpected requests")
+		})
+	}
+}
+
+func TestProbeCache(t *testing.T) {
+	var mu sync.Mutex
+	requests := 0
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer external.Close()
+
+	page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(head + `
+<a href="` + external.URL + `/a">a</a>
+<a href="` + external.URL + `/b">b</a>
+<a href="` + external.URL + `/missing">missing</a>
+` + foot))
+		noErr(t, err)
+	}))
+	defer page.Close()
+
+	file, err := ioutil.TempFile("", "httpsyet-cache")
+	noErr(t, err)
+	noErr(t, file.Close())
+	noErr(t, os.Remove(file.Name()))
+	defer os.Remove(file.Name())
+
+	for run := 1; run <= 2; run++ {
+		cache, err := httpsyet.LoadProbeCache(file.Name())
+		noErr(t, err)
+		var errs bytes.Buffer
+		err = httpsyet.Crawler{
+			Out:   ioutil.Discard,
+			Log:   log.New(&errs, "", 0),
+			Sites: []string{page.URL},
+			Cache: cache,
+		}.Run()
+		noErr(t, err)
+		noErr(t, cache.Save(file.Name()))
+		eqLines(t, fmt.Sprintf("404 %s/missing on page %s", external.URL, page.URL), strings.TrimSpace(errs.String()), "unexpected errors")
+	}
+
+	if requests != 3 {
+		t.Errorf("expected external links to be checked once; got %d requests", requests)
+	}
+}
+
+func TestProbeCacheTemporary(t *testing.T) {
+	tt := []struct {
+		name string
+		fail http.HandlerFunc // Handles the first request.
+	}{
+		{"dropped connection", func(w http.ResponseWriter, r *http.Request) {
+			conn, _, err := w.(http.Hijacker).Hijack()
+			noErr(t, err)
+			noErr(t, conn.Close())
+		}},
+		{"503", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			requests := 0
+			external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests++
+				first := requests == 1
+				mu.Unlock()
+				if first {
+					tc.fail(w, r)
+				}
+			}))
+			defer external.Close()
+
+			page := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, err := w.Write([]byte(head + `<a href="` + external.URL + `/a">a</a>` + foot))
+				noErr(t, err)
+			}))
+			defer page.Close()
+
+			cache := &httpsyet.ProbeCache{}
+			for run := 1; run <= 2; run++ {
+				var errs bytes.Buffer
+				err := httpsyet.Crawler{
+					Out:   ioutil.Discard,
+					Log:   log.New(&errs, "", 0),
+					Sites: []string{page.URL},
+					Probe: httpsyet.ProbeGet,
+					Cache: cache,
+				}.Run()
+				noErr(t, err)
+				if run == 1 && errs.Len() == 0 {
+					t.Error("expected temporary error to be reported")
+				}
+				if run == 2 && errs.Len() != 0 {
+					t.Errorf("expected no errors on second run; got %s", errs.String())
+				}
+			}
+
+			if requests != 2 {
+				t.Errorf("expected link to be checked again after temporary error; got %d requests", requests)
+			}
+		})
+	}
+}
+
+func TestPageStore(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	serve := func(html string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("ETag", `"v1"`)
+			status := "200"
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				status = "304"
+			} else {
+				_, err := w.Write([]byte(head + html + foot))
+				noErr(t, err)
+			}
+			mu.Lock()
+			requests = append(requests, status+" "+r.URL.Path)
+			mu.Unlock()
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/base", serve(`<a href="/old">sub</a>`))
+	mux.Handle("/old", http.RedirectHandler("/sub", http.StatusMovedPermanently))
+	mux.HandleFunc("/sub", serve(`<a href="/404">missing</a>`))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	file, err := ioutil.TempFile("", "httpsyet-pages")
+	noErr(t, err)
+	noErr(t, file.Close())
+	noErr(t, os.Remove(file.Name()))
+	defer os.Remove(file.Name())
+
+	for run := 1; run <= 3; run++ {
+		pages, err := httpsyet.LoadPageStore(file.Name())
+		noErr(t, err)
+		var errs bytes.Buffer
+		err = httpsyet.Crawler{
+			Out:   ioutil.Discard,
+			Log:   log.New(&errs, "", 0),
+			Sites: []string{server.URL + "/base"},
+			Pages: pages,
+		}.Run()
+		noErr(t, err)
+		if run == 2 {
+			// Expire all pages.
+			pages.TTL = time.Nanosecond
+		}
+		noErr(t, pages.Save(file.Name()))
+		eqLines(t, fmt.Sprintf("404 %[1]s/404 on page %[1]s/old", server.URL), strings.TrimSpace(errs.String()), "unexpected errors")
+	}
+
+	eqLines(t, "200 /base\n200 /sub\n304 /base\n304 /sub\n200 /base\n200 /sub", strings.Join(requests, "\n"), "unexpected requests")
+}
+
+func TestRetry(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/flaky", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls["flaky"]++
+		n := calls["flaky"]
+		mu.Unlock()
+		if n < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, err := w.Write([]byte(head + `<a href="/down">down</a>` + foot))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/down", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	var logs, findings bytes.Buffer
+	p := &httpsyet.Progress{}
+	err := httpsyet.Crawler{
+		Out:      ioutil.Discard,
+		Logger:   slog.New(slog.NewJSONHandler(&logs, &slog.HandlerOptions{Level: slog.LevelDebug})),
+		Findings: &findings,
+		Sites:    []string{server.URL + "/flaky"},
+		Retry:    httpsyet.Retry{Max: 2, Backoff: time.Millisecond},
+		Progress: p,
+	}.Run()
+	noErr(t, err)
+
+	var got []string
+	dec := json.NewDecoder(&logs)
+	for dec.More() {
+		var r struct {
+			Msg      string
+			URL      string
+			Status   int
+			Attempts int
+		}
+		noErr(t, dec.Decode(&r))
+		if r.Attempts > 0 {
+			got = append(got, fmt.Sprintf("%s %s %d %d", r.Msg, strings.TrimPrefix(r.URL, server.URL), r.Status, r.Attempts))
+		}
+	}
+	eqLines(t, "GET /flaky 200 3\nGET /down 502 3\nbroken link /down 502 3", strings.Join(got, "\n"), "unexpected records")
+	if retries := p.Stats().Retries; retries != 4 {
+		t.Errorf("expected 4 retries; got %d", retries)
+	}
+	var f httpsyet.Finding
+	noErr(t, json.Unmarshal(findings.Bytes(), &f))
+	if f.Attempts != 3 {
+		t.Errorf("expected finding with 3 attempts; got %d", f.Attempts)
+	}
+}
+
+func TestFindings(t *testing.T) {
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/base", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(head + `
+<h2 id="self">Links</h2>
+<a href="#self">self</a>
+<a href="#gone">gone</a>
+<a href="/missing">missing</a>
+<a href="/error">error</a>
+<a href="/soft">soft</a>
+<a href="/article">article</a>
+<a href="/anchors#exists">exists</a>
+<a href="/data#key">data</a>
+<a href="/invalid">invalid</a>
+<a href="/anchors#nope">nope</a>
+<a href="` + closed.URL + `/">closed</a>
+<a href="http://httpsyet.invalid/">unknown</a>
+<a href="example.com/page">bare</a>
+<a href="www.example.com">www</a>
+<a href="127.0.0.1:1234">ip</a>
+<a href="report.docx">file</a>
+<a href="data.xlsx">file</a>
+<a href="setup.exe">file</a>
+<a href="page.shtml">file</a>
+<a href="book.epub">file</a>
+<a href="main.go">file</a>
+<a href="notes.py">file</a>
+<a href="slides.pptx">file</a>
+<a href="tel:+123">phone</a>
+<a href="//` + r.Host + `/anchors">protocol-relative</a>
+` + foot))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/soft", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`<html><head><title>Page Not Found</title></head></html>`))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/article", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(`<html><head><title>How to fix 404 errors on your site</title></head></html>`))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/anchors", func(w http.ResponseWriter, r *http.Request) {
+		_, err := w.Write([]byte(head + `<a name="exists">here</a>` + foot))
+		noErr(t, err)
+	})
+	mux.HandleFunc("/data", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"key": 1}`))
+		noErr(t, err)
+	})
+	// Working links are not broken if their content cannot be parsed.
+	mux.HandleFunc("/invalid", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, err := w.Write([]byte(`{"key":`))
+		noErr(t, err)
+	})
+	// Relative links to files ending in public suffixes like py.
+	for _, f := range []string{"report.docx", "data.xlsx", "setup.exe", "page.shtml", "book.epub", "main.go", "notes.py", "slides.pptx"} {
+		mux.HandleFunc("/"+f, func(w http.ResponseWriter, r *http.Request) {})
+	}
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	file := filepath.Join(t.TempDir(), "suffixes.dat")
+	noErr(t, ioutil.WriteFile(file, []byte("com\npy\ngo.id\n"), 0644))
+	suffixes, err := httpsyet.LoadPublicSuffixList(file)
+	noErr(t, err)
+
+	var findings bytes.Buffer
+	err = httpsyet.Crawler{
+		Out:      ioutil.Discard,
+		Log:      log.New(ioutil.Discard, "", 0),
+		Sites:    []string{server.URL + "/base"},
+		Findings: &findings,
+		Suffixes: suffixes,
+	}.Run()
+	noErr(t, err)
+
+	var got []string
+	dec := json.NewDecoder(&findings)
+	for dec.More() {
+		var f httpsyet.Finding
+		noErr(t, dec.Decode(&f))
+		u := strings.NewReplacer(server.URL, "", closed.URL, "closed").Replace(f.URL)
+		got = append(got, fmt.Sprintf("%s %s %d", f.Category, u, f.Status))
+	}
+	eqLines(t, `4xx /missing 404
+5xx /error 500
+soft_404 /soft 200
+missing_fragment /base#gone 0
+missing_fragment /anchors#nope 0
+connection_refused closed/ 0
+dns http://httpsyet.invalid/ 0
+bare_domain example.com/page 0
+4xx /example.com/page 404
+bare_domain www.example.com 0
+4xx /www.example.com 404
+bare_domain 127.0.0.1:1234 0
+4xx /127.0.0.1:1234 404
+protocol_relative /anchors 0`, strings.Join(got, "\n"), "unexpected findings")
+}
+
+func TestResolve(t *testing.T) {
+	tt := []struct {
+		page   string // Path of the page containing the link.
+		html   string
+		expect string // Request made for the link.
+	}{
+		{"/blog/post-1", `<a href="post-2">`, "/blog/post-2"},
+		{"/blog/", `<a href="post-2">`, "/blog/post-2"},
+		{"/blog", `<a href="post-2">`, "/post-2"},
+		{"/blog/post-1", `<a href="../about">`, "/about"},
+		{"/blog/post-1", `<a href="../../../about">`, "/about"},
+		{"/blog/post-1", `<a href="./">`, "/blog/"},
+		{"/blog/post-1", `<a href="?page=2">`, "/blog/post-1?page=2"},
+		{"/blog/post-1?page=2", `<a href="?page=3">`, "/blog/post-1?page=3"},
+		{"/blog/post-1", `<a href="g;x=1">`, "/blog/g;x=1"},
+		{"/blog/post-1", `<a href="/a/./b/../c">`, "/a/c"},
+		{"/a/b.c/d", `<a href="e">`, "/a/b.c/e"},
+		{"/v1.2", `<a href="docs">`, "/docs"},
+		{"/old", `<a href="sibling">`, "/new/dir/sibling"},
+		{"/blog/post-1", `<base href="/static/"><a href="img">`, "/static/img"},
+		{"/blog/post-1", `<base href="sub/"><a href="img">`, "/blog/sub/img"},
+		{"/old", `<base href="../base/"><a href="img">`, "/new/base/img"},
+		{"/blog/post-1", `<base href="/static/"><a href="#anchor">`, "/static/"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.page+" "+tc.html, func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.URL.RequestURI())
+				mu.Unlock()
+				switch r.URL.RequestURI() {
+				case "/old":
+					http.Redirect(w, r, "/new/dir/page", http.StatusMovedPermanently)
+				case tc.page, "/new/dir/page":
+					_, err := w.Write([]byte(head + tc.html + foot))
+					noErr(t, err)
+				}
+			}))
+			defer server.Close()
+
+			var errs bytes.Buffer
+			err := httpsyet.Crawler{
+				Out:   ioutil.Discard,
+				Log:   log.New(&errs, "", 0),
+				Sites: []string{server.URL + tc.page},
+				Depth: 2,
+			}.Run()
+			noErr(t, err)
+			eqLines(t, "", strings.TrimSpace(errs.String()), "unexpected errors")
+
+			mu.Lock()
+			defer mu.Unlock()
+			got := requests[len(requests)-1]
+			if len(requests) < 2 || got != tc.expect {
+				t.Errorf("expected link to resolve to %s; got requests %v", tc.expect, requests)
+			}
+		})
+	}
+}
+
+func TestScope(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := `<a href="deep">deep</a>`
+		if r.URL.Path == "/" {
+			body = `
+<a href="http://www.example.com/a/">www</a>
+<a href="http://docs.example.com/b/">subdomain</a>
+<a href="http://other.org/c/">other</a>
+<a href="http://cdn.example.net/d/">allowed</a>
+<a href="http://example.com/docs/e/">prefix</a>
+<a href="http://example.com/f/">same host</a>
+<a href="http://other.co.uk/g/">other public suffix</a>
+<a href="http://shop.example.co.uk/h/">subdomain with public suffix</a>
+`
+		}
+		_, err := w.Write([]byte(head + body + foot))
+		noErr(t, err)
+	}))
+	defer server.Close()
+	target, err := url.Parse(server.URL)
+	noErr(t, err)
+
+	file := filepath.Join(t.TempDir(), "suffixes.dat")
+	noErr(t, ioutil.WriteFile(file, []byte("// Comment\ncom\nuk\nco.uk\n*.ck\n!www.ck\n"), 0644))
+	suffixes, err := httpsyet.LoadPublicSuffixList(file)
+	noErr(t, err)
+
+	tt := []struct {
+		name   string
+		site   string
+		scope  httpsyet.Scope
+		expect string // Pages which have been parsed.
+	}{
+		{"default", "http://example.com/", httpsyet.Scope{},
+			"example.com/docs/e/\nexample.com/f/"},
+		{"host", "http://example.com/", httpsyet.Scope{Mode: httpsyet.ScopeHost},
+			"example.com/docs/e/\nexample.com/f/"},
+		{"domain", "http://example.com/", httpsyet.Scope{Mode: httpsyet.ScopeDomain},
+			"example.com/docs/e/\nexample.com/f/\nwww.example.com/a/\ndocs.example.com/b/"},
+		{"hosts", "http://example.com/", httpsyet.Scope{Hosts: []string{"*.example.net"}},
+			"example.com/docs/e/\nexample.com/f/\ncdn.example.net/d/"},
+		{"prefix", "http://example.com/", httpsyet.Scope{Prefix: "/docs/"},
+			"example.com/docs/e/"},
+		{"public suffix", "http://www.example.co.uk/", httpsyet.Scope{Mode: httpsyet.ScopeDomain},
+			"shop.example.co.uk/h/"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var parsed []string
+			// Route all hosts to the test server.
+			fetcher := httpsyet.FetcherFunc(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Scheme != "http" {
+					return nil, errors.New("no https")
+				}
+				if strings.HasSuffix(r.URL.Path, "/deep") {
+					mu.Lock()
+					parsed = append(parsed, r.URL.Host+strings.TrimSuffix(r.URL.Path, "deep"))
+					mu.Unlock()
+				}
+				req := r.Clone(r.Context())
+				req.URL.Host = target.Host
+				res, err := http.DefaultClient.Do(req)
+				if err == nil {
+					res.Request = r
+				}
+				return res, err
+			})
+
+			var errs bytes.Buffer
+			err := httpsyet.Crawler{
+				Out:      ioutil.Discard,
+				Log:      log.New(&errs, "", 0),
+				Sites:    []string{tc.site},
+				Fetcher:  fetcher,
+				Scopes:   map[string]httpsyet.Scope{tc.site: tc.scope},
+				Suffixes: suffixes,
+			}.Run()
+			noErr(t, err)
+			eqLines(t, "", strings.TrimSpace(errs.String()), "unexpected errors")
+			eqLines(t, tc.expect, strings.Join(parsed, "\n"), "unexpected pages parsed")
+		})
+	}
+
+	err = httpsyet.Crawler{
+		Out:    ioutil.Discard,
+		Log:    log.New(ioutil.Discard, "", 0),
+		Sites:  []string{"http://example.com/"},
+		Scopes: map[string]httpsyet.Scope{"http://other.com/": {}},
+	}.Run()
+	doErr(t, "scope for unknown site 'http://other.com/'", err)
+
+	empty := filepath.Join(t.TempDir(), "empty.dat")
+	noErr(t, ioutil.WriteFile(empty, []byte("// No rules\n"), 0644))
+	none, err := httpsyet.LoadPublicSuffixList(empty)
+	noErr(t, err)
+	err = httpsyet.Crawler{
+		Out:      ioutil.Discard,
+		Log:      log.New(ioutil.Discard, "", 0),
+		Sites:    []string{"http://example.com/"},
+		Scope:    httpsyet.Scope{Mode: httpsyet.ScopeDomain},
+		Suffixes: none,
+	}.Run()
+	doErr(t, "scope domain requires a public suffix list but none is loaded", err)
+}
+
+func TestBudget(t *testing.T) {
+	// An endless calendar.
+	calendar := func(requests *int64) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt64(requests, 1)
+			var n int
+			_, _ = fmt.Sscanf(r.URL.Path, "/day/%d", &n)
+			_, err := fmt.Fprintf(w, head+`<a href="/day/%d">next</a>`+foot, n+1)
+			noErr(t, err)
+		}))
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var requests int64
+		server := calendar(&requests)
+		defer server.Close()
+		var errs, unexplored bytes.Buffer
+		p := &httpsyet.Progress{}
+		err := httpsyet.Crawler{
+			Out:        ioutil.Discard,
+			Log:        log.New(&errs, "", 0),
+			Sites:      []string{server.URL},
+			Parallel:   1,
+			Progress:   p,
+			Budget:     httpsyet.Budget{Pages: 3},
+			Unexplored: &unexplored,
+		}.Run()
+		noErr(t, err)
+		eqLines(t, "budget of 3 pages exhausted; remaining pages are not crawled", strings.TrimSpace(errs.String()), "unexpected errors")
+		eqLines(t, server.URL+"/day/3", strings.TrimSpace(unexplored.String()), "unexpected unexplored pages")
+		if requests != 3 || p.Stats().Unexplored != 1 {
+			t.Errorf("expected 3 requests and 1 unexplored page; got %d and %d", requests, p.Stats().Unexplored)
+		}
+	})
+
+	t.Run("site pages", func(t *testing.T) {
+		var requests1, requests2 int64
+		server1, server2 := calendar(&requests1), calendar(&requests2)
+		defer server1.Close()
+		defer server2.Close()
+		var errs bytes.Buffer
+		err := httpsyet.Crawler{
+			Out:        ioutil.Discard,
+			Log:        log.New(&errs, "", 0),
+			Sites:      []string{server1.URL, server2.URL},
+			SiteBudget: httpsyet.Budget{Pages: 2},
+		}.Run()
+		noErr(t, err)
+		eqLines(t, fmt.Sprintf(`budget of 2 pages exhausted for site %s; remaining pages are not crawled
+budget of 2 pages exhausted for site %s; remaining pages are not crawled`, server1.URL, server2.URL), strings.TrimSpace(errs.String()), "unexpected errors")
+		if requests1 != 2 || requests2 != 2 {
+			t.Errorf("expected 2 requests per site; got %d and %d", requests1, requests2)
+		}
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		var requests int64
+		server := calendar(&requests)
+		defer server.Close()
+		var errs bytes.Buffer
+		err := httpsyet.Crawler{
+			Out:      ioutil.Discard,
+			Log:      log.New(&errs, "", 0),
+			Sites:    []string{server.URL},
+			Parallel: 1,
+			Budget:   httpsyet.Budget{Bytes: 1},
+		}.Run()
+		noErr(t, err)
+		eqLines(t, "budget of 1 bytes exhausted; remaining pages are not crawled", strings.TrimSpace(errs.String()), "unexpected errors")
+		if requests != 1 {
+			t.Errorf("expected 1 request; got %d", requests)
+		}
+	})
+
+	t.Run("duration", func(t *testing.T) {
+		var requests int64
+		server := calendar(&requests)
+		defer server.Close()
+		var errs bytes.Buffer
+		err := httpsyet.Crawler{
+			Out:      ioutil.Discard,
+			Log:      log.New(&errs, "", 0),
+			Sites:    []string{server.URL},
+			Parallel: 1,
+			Delay:    10 * time.Millisecond,
+			Budget:   httpsyet.Budget{Duration: 50 * time.Millisecond},
+		}.Run()
+		noErr(t, err)
+		eqLines(t, "budget of 50ms exhausted; remaining pages are not crawled", strings.TrimSpace(errs.String()), "unexpected errors")
+		if requests < 2 || requests > 6 {
+			t.Errorf("expected about 5 requests; got %d", requests)
+		}
+	})
+
+	t.Run("page size", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/" {
+				return
+			}
+			_, err := w.Write([]byte(head + `<a href="/kept">kept</a><a href="#cut">cut</a>` + strings.Repeat(" ", 1000) + `<a id="cut" href="/cut">cut</a>` + foot))
+			noErr(t, err)
+		}))
+		defer server.Close()
+		var errs, out, findings bytes.Buffer
+		err := httpsyet.Crawler{
+			Out:      ioutil.Discard,
+			Log:      log.New(&errs, "", 0),
+			Findings: &findings,
+			Sites:    []string{server.URL},
+			Verbose:  true,
+			Budget:   httpsyet.Budget{PageSize: 500},
+		}.Run()
+		noErr(t, err)
+		if findings.Len() != 0 {
+			t.Errorf("expected no findings for anchors after the limit; got %s", findings.String())
+		}
+		lines := strings.Split(strings.TrimSpace(errs.String()), "\n")
+		for _, l := range lines {
+			if strings.HasPrefix(l, "page ") || strings.Contains(l, "/kept") || strings.Contains(l, "/cut") {
+				out.WriteString(l + "\n")
+			}
+		}
+		eqLines(t, fmt.Sprintf("page %s truncated after 500 bytes\nverbose: GET %s/kept", server.URL, server.URL), strings.TrimSpace(out.String()), "unexpected log")
+	})
+}
+
+func TestCancel(t *testing.T) {
+	// A host that never responds.
+	stop := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-stop:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(stop)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	var errs bytes.Buffer
+	done := make(chan error, 1)
+	go func() {
+		done <- httpsyet.Crawler{
+			Out:   ioutil.Discard,
+			Log:   log.New(&errs, "", 0),
+			Sites: []string{server.URL},
+		}.RunContext(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		if err != context.DeadlineExceeded {
+			t.Errorf("expected context error; got %v", err)
+		}
+		if errs.Len() > 0 {
+			t.Errorf("expected aborted requests not to be reported; got %s", errs.String())
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request in progress has not been aborted")
+	}
+}
+
+func TestTraps(t *testing.T) {
+	tests := []struct {
+		name     string
+		traps    httpsyet.Traps
+		link     func(u *url.URL) string // Next link of a page.
+		requests int
+		trap     string // URL of the trap, relative to the server.
+		page     string
+		err      string // %s is replaced with the server URL.
+	}{
+		{
+			name:     "repeats",
+			traps:    httpsyet.Traps{Repeats: 2},
+			link:     func(u *url.URL) string { return "loop/" },
+			requests: 3,
+			trap:     "/loop/loop/loop/",
+			page:     "/loop/loop/",
+			err:      "crawler trap: path segments of %s/loop repeat more than 2 times; further URLs are not crawled",
+		},
+		{
+			name:  "queries",
+			traps: httpsyet.Traps{Queries: 3},
+			link: func(u *url.URL) string {
+				var n int
+				_, _ = fmt.Sscanf(u.Query().Get("page"), "%d", &n)
+				return fmt.Sprintf("/shop?page=%d", n+1)
+			},
+			requests: 4,
+			trap:     "/shop?page=4",
+			page:     "/shop?page=3",
+			err:      "crawler trap: more than 3 query strings for %s/shop; further URLs are not crawled",
+		},
+		{
+			name:  "sessions",
+			traps: httpsyet.Traps{Sessions: 1, Queries: 3},
+			link: func(u *url.URL) string {
+				var n int
+				_, _ = fmt.Sscanf(u.Path, "/about;jsessionid=%d", &n)
+				return fmt.Sprintf("/about;jsessionid=%d?lang=en", n+1)
+			},
+			requests: 2,
+			trap:     "/about;jsessionid=2?lang=en",
+			page:     "/about;jsessionid=1?lang=en",
+			err:      "crawler trap: more than 1 URLs only differ in session IDs from %s/about?lang=en; further URLs are not crawled",
+		},
+		{
+			name:  "dates",
+			traps: httpsyet.Traps{Dates: 3},
+			link: func(u *url.URL) string {
+				var month int
+				_, _ = fmt.Sscanf(u.Path, "/calendar/2024-%d", &month)
+				return fmt.Sprintf("/calendar/2024-%02d", month+1)
+			},
+			requests: 4,
+			trap:     "/calendar/2024-04",
+			page:     "/calendar/2024-03",
+			err:      "crawler trap: more than 3 URLs only differ in dates like %s/calendar/{date}; further URLs are not crawled",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int64
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&requests, 1)
+				_, err := fmt.Fprintf(w, head+`<a href="%s">next</a>`+foot, tt.link(r.URL))
+				noErr(t, err)
+			}))
+			defer server.Close()
+
+			var errs, findings bytes.Buffer
+			err := httpsyet.Crawler{
+				Out:      ioutil.Discard,
+				Log:      log.New(&errs, "", 0),
+				Sites:    []string{server.URL},
+				Parallel: 1,
+				Findings: &findings,
+				Traps:    tt.traps,
+			}.Run()
+			noErr(t, err)
+
+			msg := fmt.Sprintf(tt.err, server.URL)
+			eqLines(t, msg+" on page "+server.URL+tt.page, strings.TrimSpace(errs.String()), "unexpected errors")
+			var f httpsyet.Finding
+			noErr(t, json.Unmarshal(findings.Bytes(), &f))
+			expected := httpsyet.Finding{Category: httpsyet.CategoryTrap, URL: server.URL + tt.trap, Page: server.URL + tt.page, Error: msg}
+			if f != expected {
+				t.Errorf("expected finding %+v; got %+v", expected, f)
+			}
+			if int(requests) != tt.requests {
+				t.Errorf("expected %d requests; got %d", tt.requests, requests)
+			}
+		})
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		err := httpsyet.Crawler{
+			Out:   ioutil.Discard,
+			Log:   log.New(ioutil.Discard, "", 0),
+			Sites: []string{"http://localhost"},
+			Traps: httpsyet.Traps{Dates: -1},
+		}.Run()
+		if err == nil || err.Error() != "trap caps cannot be negative" {
+			t.Errorf("expected error for negative cap; got %v", err)
+		}
+	})
+}
+
+func TestRobots(t *testing.T) {
+	for _, robots := range []bool{false, true} {
+		t.Run(fmt.Sprint(robots), func(t *testing.T) {
+			var mu sync.Mutex
+			var requests []string
+			pages := map[string]string{
+				"/dir/page": `<base href="/assets/">
+<a href="rel">relative to base</a>
+<a href=" /nofollow
+" rel="external nofollow">nofollow</a>
+<a href="/gone" rel="nofollow">gone</a>
+<a href="/meta">meta</a>`,
+				"/assets/rel":    ``,
+				"/nofollow":      `<a href="/deep-nofollow">deep</a>`,
+				"/meta":          `<meta name="robots" content="noindex, nofollow"><a href="/deep-meta">deep</a>`,
+				"/deep-nofollow": ``,
+				"/deep-meta":     ``,
+			}
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				requests = append(requests, r.URL.Path)
+				mu.Unlock()
+				body, ok := pages[r.URL.Path]
+				if !ok {
+					http.NotFound(w, r)
+					return
+				}
+				_, err := w.Write([]byte(head + body + foot))
+				noErr(t, err)
+			}))
+			defer server.Close()
+
+			var findings bytes.Buffer
+			err := httpsyet.Crawler{
+				Out:      ioutil.Discard,
+				Log:      log.New(ioutil.Discard, "", 0),
+				Sites:    []string{server.URL + "/dir/page"},
+				Findings: &findings,
+				Robots:   robots,
+			}.Run()
+			noErr(t, err)
+
+			var f httpsyet.Finding
+			noErr(t, json.Unmarshal(findings.Bytes(), &f))
+			if f.URL != server.URL+"/gone" || !f.NoFollow {
+				t.Errorf("expected nofollow finding for /gone; got %+v", f)
+			}
+
+			// Links marked nofollow are checked but their pages are not parsed.
+			expect := "/dir/page\n/assets/rel\n/nofollow\n/gone\n/meta\n/deep-meta"
+			if !robots {
+				expect += "\n/deep-nofollow"
+			}
+			eqLines(t, expect, strings.Join(requests, "\n"), "unexpected requests")
+		})
+	}
+}
+
+func TestRobotsLinkedElsewhere(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]int{}
+	pages := map[string]string{
+		"/":  `<a href="/a" rel="nofollow">a</a><a href="/b">b</a>`,
+		"/b": `<a href="/a">a</a>`,
+		"/a": `<a href="/c">c</a>`,
+		"/c": ``,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path]++
+		mu.Unlock()
+		_, err := w.Write([]byte(head + pages[r.URL.Path] + foot))
+		noErr(t, err)
+	}))
+	defer server.Close()
+
+	err := httpsyet.Crawler{
+		Out:    ioutil.Discard,
+		Log:    log.New(ioutil.Discard, "", 0),
+		Sites:  []string{server.URL},
+		Robots: true,
+	}.Run()
+	noErr(t, err)
+
+	// Pages linked with nofollow are still crawled if they are also linked normally.
+	if requested["/c"] != 1 {
+		t.Errorf("expected /c to be crawled once; got %v", requested)
+	}
+}
+
+func TestContentTypes(t *testing.T) {
+	var mu sync.Mutex
+	requested := map[string]bool{}
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requested[r.URL.Path] = true
+		mu.Unlock()
+	}))
+	defer external.Close()
+	ext := external.URL
+
+	files := map[string]struct{ contentType, body string }{
+		"/": {"text/html; charset=utf-8", head + `
+<a href="/feed.rss">rss</a>
+<a href="/feed.atom">atom</a>
+<a href="/data.json">json</a>
+<a href="/sitemap.xml">xml</a>
+<a href="/app.js">js</a>
+<a href="/notes.txt">text</a>
+<a href="/image.png">image</a>
+` + foot},
+		"/feed.rss": {"application/rss+xml", `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+	<link>` + ext + `/rss-link</link>
+	<item>
+		<enclosure url="` + ext + `/rss-enclosure" type="audio/mpeg"/>
+		<description>&lt;a href="` + ext + `/rss-content"&gt;more&lt;/a&gt;</description>
+	</item>
+</channel></rss>`},
+		"/feed.atom": {"application/atom+xml", `<feed xmlns="http://www.w3.org/2005/Atom">
+	<link href="` + ext + `/atom-link"/>
+	<entry><content type="html">&lt;a href="` + ext + `/atom-content"&gt;more&lt;/a&gt;</content></entry>
+</feed>`},
+		"/data.json": {"application/json", `{"items": [{"url": "` + ext + `/json", "name": "not a link"}]}`},
+		"/sitemap.xml": {"", `<?xml version="1.0"?>
+<urlset><url><loc>` + ext + `/xml-text</loc></url><other ref="` + ext + `/xml-attr"/></urlset>`},
+		"/app.js":    {"text/javascript", `fetch("` + ext + `/js-double"); load('` + ext + `/js-single'); var x = "no link";`},
+		"/notes.txt": {"text/plain", `See ` + ext + `/text.`},
+		"/image.png": {"image/png", "\x89PNG " + ext + "/image"},
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if f.contentType != "" {
+			w.Header().Set("Content-Type", f.contentType)
+		}
+		_, err := w.Write([]byte(f.body))
+		noErr(t, err)
+	}))
+	defer server.Close()
+
+	var errs bytes.Buffer
+	err := httpsyet.Crawler{
+		Out:   ioutil.Discard,
+		Log:   log.New(&errs, "", 0),
+		Sites: []string{server.URL},
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "", strings.TrimSpace(errs.String()), "unexpected errors")
+
+	var got []string
+	for p := range requested {
+		got = append(got, p)
+	}
+	sort.Strings(got)
+	eqLines(t, `/atom-content
+/atom-link
+/js-double
+/js-single
+/json
+/rss-content
+/rss-enclosure
+/rss-link
+/text
+/xml-attr
+/xml-text`, strings.Join(got, "\n"), "unexpected requests")
+}
+
+func TestRenderer(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	record := func(r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+	}
+	external := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+	}))
+	defer external.Close()
+
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		if r.URL.Path == "/" {
+			_, err := w.Write([]byte(head + `<div id="app"></div>` + foot))
+			noErr(t, err)
+		}
+	}))
+	defer site.Close()
+
+	var navigated []string
+	devtools := fakeDevTools(t, func(method string, params map[string]interface{}, send func(v interface{})) interface{} {
+		switch method {
+		case "Page.navigate":
+			u := params["url"].(string)
+			navigated = append(navigated, u)
+			for _, r := range []string{u, external.URL + "/mixed.png", "data:image/png;base64,"} {
+				send(map[string]interface{}{"method": "Network.requestWillBeSent", "params": map[string]interface{}{"request": map[string]string{"url": r}}})
+			}
+			send(map[string]interface{}{"method": "Page.loadEventFired", "params": map[string]interface{}{}})
+			return map[string]string{"frameId": "1"}
+		case "Runtime.evaluate":
+			return map[string]interface{}{"result": map[string]string{"type": "string", "value": `<html><body><a href="/rendered">rendered</a></body></html>`}}
+		}
+		return map[string]string{}
+	})
+	defer devtools.Close()
+
+	f, err := httpsyet.NewRenderer(httpsyet.RenderOptions{Endpoint: devtools.URL, Wait: 10 * time.Millisecond})
+	noErr(t, err)
+
+	var errs bytes.Buffer
+	err = httpsyet.Crawler{
+		Out:     ioutil.Discard,
+		Log:     log.New(&errs, "", 0),
+		Sites:   []string{site.URL},
+		Fetcher: f,
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "", strings.TrimSpace(errs.String()), "unexpected errors")
+	eqLines(t, site.URL, strings.Join(navigated, "\n"), "unexpected pages rendered")
+	eqLines(t, "GET /\nGET /rendered\nHEAD /mixed.png", strings.Join(requests, "\n"), "unexpected requests")
+
+	_, err = httpsyet.NewRenderer(httpsyet.RenderOptions{Endpoint: "localhost:9222"})
+	doErr(t, "invalid DevTools endpoint 'localhost:9222': expected URL like http://localhost:9222", err)
+}
+
+func TestRenderedHeader(t *testing.T) {
+	var mu sync.Mutex
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.URL.Path)
+		mu.Unlock()
+		// Sites cannot add links via headers.
+		w.Header().Set("Httpsyet-Rendered-Request", "http://"+r.Host+"/injected")
+		_, err := w.Write([]byte(head + foot))
+		noErr(t, err)
+	}))
+	defer server.Close()
+
+	err := httpsyet.Crawler{
+		Out:   ioutil.Discard,
+		Log:   log.New(ioutil.Discard, "", 0),
+		Sites: []string{server.URL},
+	}.Run()
+	noErr(t, err)
+	eqLines(t, "/", strings.Join(requests, "\n"), "unexpected requests")
+}
+
+// Returns a server speaking the DevTools protocol for a single tab.
+// Calls are answered with the result of handle which can send events before.
+func fakeDevTools(t *testing.T, handle func(method string, params map[string]interface{}, send func(v interface{})) interface{}) *httptest.Server {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/json/new":
+			noErr(t, json.NewEncoder(w).Encode(map[string]string{
+				"id":                   "tab",
+				"webSocketDebuggerUrl": "ws://" + r.Host + "/devtools/page/tab",
+			}))
+		case r.URL.Path == "/json/close/tab":
+		case r.URL.Path == "/devtools/page/tab":
+			h := sha1.Sum([]byte(r.Header.Get("Sec-WebSocket-Key") + "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"))
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			defer conn.Close()
+			fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %s\r\n\r\n", base64.StdEncoding.EncodeToString(h[:]))
+			noErr(t, rw.Flush())
+			send := func(v interface{}) {
+				b, err := json.Marshal(v)
+				noErr(t, err)
+				writeFrame(rw, b)
+			}
+			for {
+				b, err := readFrame(rw.Reader)
+				if err != nil {
+					return
+				}
+				var call struct {
+					ID     int                    `json:"id"`
+					Method string                 `json:"method"`
+					Params map[string]interface{} `json:"params"`
+				}
+				noErr(t, json.Unmarshal(b, &call))
+				send(map[string]interface{}{"id": call.ID, "result": handle(call.Method, call.Params, send)})
+			}
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	return server
+}
+
+// Write an unmasked server frame.
+func writeFrame(w *bufio.ReadWriter, b []byte) {
+	header := []byte{0x81}
+	switch {
+	case len(b) < 126:
+		header = append(header, byte(len(b)))
+	default:
+		header = append(header, 126, byte(len(b)>>8), byte(len(b)))
+	}
+	_, _ = w.Write(append(header, b...))
+	_ = w.Flush()
+}
+
+// Read a masked client frame.
+// Returns io.EOF for close frames.
+func readFrame(r *bufio.Reader) ([]byte, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0]&0x0f == 0x8 {
+		return nil, io.EOF
+	}
+	n := int(header[1] & 0x7f)
+	if n == 126 {
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		n = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	b := make([]byte, 4+n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	mask, payload := b[:4], b[4:]
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return payload, nil
+}
+
 func TestConfig(t *testing.T) {
 	tt := []struct {
 		err string
@@ -464,6 +1850,15 @@ func TestConfig(t *testing.T) {
 				Depth: -1,
 			},
 		},
+		{
+			err: "unknown probe strategy 'post': expected head or get",
+			c: httpsyet.Crawler{
+				Out:   ioutil.Discard,
+				Log:   log.New(ioutil.Discard, "", 0),
+				Sites: []string{"https://qvl.io"},
+				Probe: "post",
+			},
+		},
 		{
 			err: "parallel cannot be negative",
 			c: httpsyet.Crawler{
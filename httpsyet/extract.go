@@ -0,0 +1,183 @@
+package httpsyet
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Extractors find links in a response body.
+var extractors = map[string]func(io.Reader) (document, error){
+	"text/html":              getLinks,
+	"application/xhtml+xml":  getLinks,
+	"application/rss+xml":    xmlLinks,
+	"application/atom+xml":   xmlLinks,
+	"application/xml":        xmlLinks,
+	"text/xml":               xmlLinks,
+	"application/json":       jsonLinks,
+	"application/ld+json":    jsonLinks,
+	"application/javascript": jsLinks,
+	"text/javascript":        jsLinks,
+	"application/ecmascript": jsLinks,
+	"text/plain":             textLinks,
+}
+
+// Returns the extractor for the content type of a response.
+// Types without a registered extractor, like images or archives, return nil.
+// The body is sniffed if the server does not set a type.
+func extractor(r *http.Response) (func(io.Reader) (document, error), io.Reader) {
+	body := bufio.NewReader(r.Body)
+	ct := r.Header.Get("Content-Type")
+	if ct == "" {
+		head, _ := body.Peek(512)
+		ct = http.DetectContentType(head)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return nil, body
+	}
+	if f, ok := extractors[mediaType]; ok {
+		return f, body
+	}
+	switch {
+	case strings.HasSuffix(mediaType, "+xml"):
+		return xmlLinks, body
+	case strings.HasSuffix(mediaType, "+json"):
+		return jsonLinks, body
+	}
+	return nil, body
+}
+
+// Elements containing a URL as text, e.g. in RSS and sitemaps.
+var urlElements = map[string]bool{"link": true, "loc": true, "url": true, "guid": true, "comments": true, "icon": true, "logo": true}
+
+// Elements containing escaped HTML in feeds.
+var htmlElements = map[string]bool{"description": true, "content": true, "encoded": true, "summary": true}
+
+// Attributes containing URLs like Atom's <link href> or RSS's <enclosure url>.
+var urlAttributes = map[string]bool{"href": true, "src": true, "url": true, "uri": true}
+
+// Find links in RSS, Atom, sitemaps and other XML documents.
+func xmlLinks(r io.Reader) (document, error) {
+	var d document
+	dec := xml.NewDecoder(r)
+	dec.Strict = false
+	var stack []string
+	for {
+		t, err := dec.Token()
+		if err == io.EOF {
+			return d, nil
+		}
+		if err != nil {
+			return d, fmt.Errorf("failed to parse xml: %v", err)
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			stack = append(stack, strings.ToLower(t.Name.Local))
+			for _, a := range t.Attr {
+				// Namespaces are identifiers, not links.
+				if a.Name.Space == "xmlns" || a.Name.Local == "xmlns" || a.Name.Local == "schemaLocation" {
+					continue
+				}
+				if urlAttributes[strings.ToLower(a.Name.Local)] || isAbsolute(a.Value) {
+					d.links = append(d.links, strings.TrimSpace(a.Value))
+				}
+			}
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		case xml.CharData:
+			if len(stack) == 0 {
+				continue
+			}
+			text := strings.TrimSpace(string(t))
+			switch el := stack[len(stack)-1]; {
+			case text == "":
+			case urlElements[el] && isAbsolute(text):
+				d.links = append(d.links, text)
+			case htmlElements[el]:
+				h, err := getLinks(strings.NewReader(text))
+				if err == nil {
+					d.links = append(d.links, h.links...)
+				}
+			}
+		}
+	}
+}
+
+// Find string values that are absolute URLs.
+func jsonLinks(r io.Reader) (document, error) {
+	var d document
+	var v interface{}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return d, fmt.Errorf("failed to parse json: %v", err)
+	}
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case string:
+			if isAbsolute(v) {
+				d.links = append(d.links, v)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case map[string]interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		}
+	}
+	walk(v)
+	return d, nil
+}
+
+// URLs in string literals.
+var jsString = regexp.MustCompile(`"(https?://[^"\s\\]+)"|'(https?://[^'\s\\]+)'|` + "`(https?://[^`\\s\\\\$]+)`")
+
+// Find absolute URLs in string literals of scripts.
+func jsLinks(r io.Reader) (document, error) {
+	var d document
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return d, fmt.Errorf("failed to read script: %v", err)
+	}
+	for _, m := range jsString.FindAllSubmatch(b, -1) {
+		for _, g := range m[1:] {
+			if len(g) > 0 {
+				d.links = append(d.links, string(g))
+			}
+		}
+	}
+	return d, nil
+}
+
+var textURL = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// Find absolute URLs in plain text.
+func textLinks(r io.Reader) (document, error) {
+	var d document
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return d, fmt.Errorf("failed to read text: %v", err)
+	}
+	for _, m := range textURL.FindAll(b, -1) {
+		// Punctuation at the end most likely belongs to the sentence.
+		d.links = append(d.links, strings.TrimRight(string(m), ".,;:!?)]}"))
+	}
+	return d, nil
+}
+
+func isAbsolute(s string) bool {
+	s = strings.TrimSpace(s)
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
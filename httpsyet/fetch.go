@@ -0,0 +1,130 @@
+package httpsyet
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// A Fetcher makes the HTTP requests of a crawler.
+// Requests carry a context, method and headers.
+// It must be safe for concurrent use.
+type Fetcher interface {
+	Fetch(*http.Request) (*http.Response, error)
+}
+
+// FetcherFunc is a function used as Fetcher.
+// For example http.DefaultClient.Do can be used as FetcherFunc.
+type FetcherFunc func(*http.Request) (*http.Response, error)
+
+// Fetch calls f(r).
+func (f FetcherFunc) Fetch(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// GetFetcher adapts a function like http.Get to the Fetcher interface.
+// Only the URL of requests is passed on; method, headers and context are ignored.
+func GetFetcher(get func(string) (*http.Response, error)) Fetcher {
+	return FetcherFunc(func(r *http.Request) (*http.Response, error) {
+		return get(r.URL.String())
+	})
+}
+
+// FetchOptions configure the Fetcher returned by NewFetcher.
+type FetchOptions struct {
+	Proxy          string        // Optional. URL of a proxy for all requests. Defaults to the proxy set in the environment.
+	CAFile         string        // Optional. PEM file with certificates trusted in addition to the system's.
+	CertFile       string        // Optional. PEM file with a client certificate. Requires KeyFile.
+	KeyFile        string        // Optional. PEM file with the key of the client certificate.
+	Header         http.Header   // Optional. Sent with every request unless the request sets the header itself.
+	Timeout        time.Duration // Optional. Limit for a whole request including reading the body.
+	ConnectTimeout time.Duration // Optional. Limit for establishing a connection including the TLS handshake.
+}
+
+// NewFetcher returns a Fetcher using an HTTP client configured by o.
+// Returns an error if files cannot be loaded.
+func NewFetcher(o FetchOptions) (Fetcher, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	if o.Proxy != "" {
+		u, err := url.Parse(o.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy '%s': %v", o.Proxy, err)
+		}
+		t.Proxy = http.ProxyURL(u)
+	}
+
+	if o.CAFile != "" || o.CertFile != "" || o.KeyFile != "" {
+		t.TLSClientConfig = &tls.Config{}
+	}
+	if o.CAFile != "" {
+		pem, err := ioutil.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %v", err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in CA file '%s'", o.CAFile)
+		}
+		t.TLSClientConfig.RootCAs = pool
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		if o.CertFile == "" || o.KeyFile == "" {
+			return nil, errors.New("client certificate requires both a cert and a key file")
+		}
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		t.TLSClientConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	if o.ConnectTimeout > 0 {
+		t.DialContext = (&net.Dialer{Timeout: o.ConnectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		t.TLSHandshakeTimeout = o.ConnectTimeout
+	}
+
+	client := &http.Client{
+		Transport: t,
+		Timeout:   o.Timeout,
+		CheckRedirect: func(r *http.Request, via []*http.Request) error {
+			if r.Context().Value(noRedirectKey{}) != nil {
+				return http.ErrUseLastResponse
+			}
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			return nil
+		},
+	}
+	if len(o.Header) == 0 {
+		return FetcherFunc(client.Do), nil
+	}
+	return WithHeader(FetcherFunc(client.Do), o.Header), nil
+}
+
+// WithHeader returns a Fetcher adding h to all requests made with f.
+// Headers already set on a request are kept.
+func WithHeader(f Fetcher, h http.Header) Fetcher {
+	return FetcherFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		for k, vs := range h {
+			if _, ok := r.Header[http.CanonicalHeaderKey(k)]; ok {
+				continue
+			}
+			for _, v := range vs {
+				r.Header.Add(k, v)
+			}
+		}
+		return f.Fetch(r)
+	})
+}
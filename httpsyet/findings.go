@@ -0,0 +1,176 @@
+package httpsyet
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Categories of broken links.
+const (
+	CategoryDNS      = "dns"                // Host not found.
+	CategoryRefused  = "connection_refused" // Nothing listening on the port.
+	CategoryTimeout  = "timeout"            // No response in time.
+	CategoryTLS      = "tls"                // Invalid certificate or failed handshake.
+	CategoryOther    = "other"              // Any other request error.
+	Category4xx      = "4xx"                // Client error status code.
+	Category5xx      = "5xx"                // Server error status code.
+	CategorySoft404  = "soft_404"           // Page says it is not found but responds with a success status code.
+	CategoryFragment = "missing_fragment"   // Link to an #id which does not exist on the page.
+
+	CategoryBareDomain       = "bare_domain"       // Link like example.com/page missing its scheme, resolved as relative path.
+	CategoryProtocolRelative = "protocol_relative" // Link like //example.com/page on an http page, which is loaded via http.
+	CategoryTrap             = "crawler_trap"      // URL pattern generating an infinite number of pages like a calendar.
+)
+
+// Finding is a broken or questionable link.
+type Finding struct {
+	Category string `json:"category"`
+	URL      string `json:"url"`
+	Page     string `json:"page,omitempty"`   // Page the link has been found on. Empty for sites given to the crawler.
+	Status   int    `json:"status,omitempty"` // Status code of the response if there was one.
+	Error    string `json:"error,omitempty"`
+	NoFollow bool   `json:"nofollow,omitempty"` // Linked with rel="nofollow" or from a page with robots meta tag nofollow.
+	Attempts int    `json:"attempts,omitempty"` // Requests made for the link including retries. Empty if not requested.
+}
+
+// ErrorCategory returns the category of a request error.
+// One of CategoryDNS, CategoryTLS, CategoryTimeout, CategoryRefused and CategoryOther.
+func ErrorCategory(err error) string {
+	var probeErr probeError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr x509.CertificateInvalidError
+	var unknownAuth x509.UnknownAuthorityError
+	var hostErr x509.HostnameError
+	switch {
+	case errors.As(err, &probeErr):
+		return probeErr.category
+	case errors.As(err, &dnsErr):
+		return CategoryDNS
+	case errors.As(err, &certErr), errors.As(err, &unknownAuth), errors.As(err, &hostErr), strings.Contains(err.Error(), "tls:"):
+		return CategoryTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return CategoryTimeout
+	case strings.Contains(err.Error(), "connection refused"):
+		return CategoryRefused
+	}
+	return CategoryOther
+}
+
+// StatusCategory returns the category of an error status code.
+func StatusCategory(status int) string {
+	if status >= 500 {
+		return Category5xx
+	}
+	return Category4xx
+}
+
+// A request error restored from a cache.
+type probeError struct {
+	msg, category string
+}
+
+func (e probeError) Error() string {
+	return e.msg
+}
+
+// Titles and headings of error pages.
+var notFound = regexp.MustCompile(`(?i)\b(404|not found|page not found|does not exist)\b`)
+
+// Reports if a title mostly consists of phrases of error pages
+// like "404 Not Found" or "Page not found | Example".
+// Titles merely mentioning them like "Fixing 404 errors" are not.
+func isNotFound(title string) bool {
+	matched := 0
+	for _, m := range notFound.FindAllString(title, -1) {
+		matched += letters(m)
+	}
+	return matched > 0 && matched*2 >= letters(title)
+}
+
+// Counts letters and digits.
+func letters(s string) int {
+	n := 0
+	for _, r := range s {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			n++
+		}
+	}
+	return n
+}
+
+// Writes findings as JSON lines.
+// Safe for concurrent use.
+type findingWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (fw *findingWriter) write(f Finding) error {
+	if fw == nil {
+		return nil
+	}
+	b, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	_, err = fw.w.Write(append(b, '\n'))
+	return err
+}
+
+// Anchors collects ids of pages and links to them
+// to find links to ids which don't exist.
+// Safe for concurrent use.
+type anchors struct {
+	mu    sync.Mutex
+	ids   map[string]map[string]bool // By page URL.
+	links []anchorLink
+}
+
+type anchorLink struct {
+	page, target, fragment string
+}
+
+func (a *anchors) addPage(u string, ids map[string]bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.ids[u] = ids
+}
+
+func (a *anchors) addLink(page, target, fragment string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.links = append(a.links, anchorLink{page: page, target: target, fragment: fragment})
+}
+
+// Returns links to ids missing on pages that have been parsed.
+// Links to pages which have not been parsed cannot be checked.
+func (a *anchors) missing() []Finding {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var fs []Finding
+	seen := map[anchorLink]bool{}
+	for _, l := range a.links {
+		ids, ok := a.ids[l.target]
+		if !ok || ids[l.fragment] || seen[l] {
+			continue
+		}
+		seen[l] = true
+		fs = append(fs, Finding{
+			Category: CategoryFragment,
+			URL:      l.target + "#" + l.fragment,
+			Page:     l.page,
+			Error:    "missing fragment #" + l.fragment,
+		})
+	}
+	return fs
+}
@@ -0,0 +1,68 @@
+package httpsyet
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+)
+
+// NewLogHandler returns a slog.Handler writing records to l
+// in the plain line format used before structured logging.
+// Warnings and errors are always written.
+// Debug records are only written if verbose is set and info records are dropped.
+func NewLogHandler(l *log.Logger, verbose bool) slog.Handler {
+	return logHandler{l: l, verbose: verbose}
+}
+
+type logHandler struct {
+	l       *log.Logger
+	verbose bool
+	attrs   []slog.Attr
+}
+
+func (h logHandler) Enabled(_ context.Context, level slog.Level) bool {
+	if level >= slog.LevelWarn {
+		return true
+	}
+	return h.verbose && level < slog.LevelInfo
+}
+
+func (h logHandler) Handle(_ context.Context, r slog.Record) error {
+	attrs := map[string]string{}
+	for _, a := range h.attrs {
+		attrs[a.Key] = a.Value.String()
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		attrs[a.Key] = a.Value.String()
+		return true
+	})
+
+	if r.Level < slog.LevelInfo {
+		h.l.Printf("verbose: %s %s\n", r.Message, attrs["url"])
+		return nil
+	}
+
+	text := r.Message
+	if err, ok := attrs["error"]; ok {
+		text = err
+	}
+	if page, ok := attrs["page"]; ok {
+		text = fmt.Sprintf("page %s: %s", page, text)
+	}
+	if parent, ok := attrs["parent"]; ok {
+		text = fmt.Sprintf("%s on page %s", text, parent)
+	}
+	h.l.Println(text)
+	return nil
+}
+
+func (h logHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.attrs = append(h.attrs[:len(h.attrs):len(h.attrs)], attrs...)
+	return h
+}
+
+// Groups are ignored since the plain format has no place for them.
+func (h logHandler) WithGroup(string) slog.Handler {
+	return h
+}
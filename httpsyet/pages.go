@@ -0,0 +1,174 @@
+package httpsyet
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPageTTL is how long pages not crawled anymore are kept in a PageStore.
+const DefaultPageTTL = 30 * 24 * time.Hour
+
+// PageStore remembers validators and links of internal pages.
+// Pages are requested conditionally using If-None-Match and If-Modified-Since.
+// If a page did not change, its links are taken from the store instead of parsing it again.
+// Pages are stored by their URL after redirects.
+// It is safe for concurrent use and can be shared between crawlers.
+// Use LoadPageStore and Save to keep it between runs.
+type PageStore struct {
+	TTL time.Duration // Optional. How long pages not crawled anymore are remembered. Defaults to DefaultPageTTL.
+
+	mu    sync.Mutex
+	pages map[string]storedPage
+}
+
+type storedPage struct {
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	Links        []string  `json:"links"`
+	IDs          []string  `json:"ids"` // Null for documents other than HTML.
+	Base         string    `json:"base,omitempty"`
+	Robots       string    `json:"robots,omitempty"`
+	NoFollow     []string  `json:"nofollow,omitempty"` // Links with rel="nofollow".
+	Redirect     string    `json:"redirect,omitempty"` // URL the page redirected to. Validators and links are stored there.
+	Seen         time.Time `json:"seen"`               // Last time the page was crawled.
+}
+
+// LoadPageStore reads a store written by Save.
+// Returns an empty store if the file does not exist.
+func LoadPageStore(file string) (*PageStore, error) {
+	s := &PageStore{}
+	b, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read page store: %v", err)
+	}
+	if err := json.Unmarshal(b, &s.pages); err != nil {
+		return nil, fmt.Errorf("invalid page store '%s': %v", file, err)
+	}
+	return s, nil
+}
+
+// Save writes all pages crawled within the TTL to file.
+// The file is replaced atomically.
+func (s *PageStore) Save(file string) error {
+	s.mu.Lock()
+	pages := map[string]storedPage{}
+	now := time.Now()
+	for u, p := range s.pages {
+		if now.Sub(p.Seen) < ttl(s.TTL, DefaultPageTTL) {
+			pages[u] = p
+		}
+	}
+	s.mu.Unlock()
+	b, err := json.Marshal(pages)
+	if err != nil {
+		return fmt.Errorf("failed to encode page store: %v", err)
+	}
+	if err := writeFile(file, b); err != nil {
+		return fmt.Errorf("failed to write page store: %v", err)
+	}
+	return nil
+}
+
+// Add conditional headers for a known page.
+func (s *PageStore) prepare(r *http.Request) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	p, ok := s.seen(r.URL.String())
+	if ok && p.Redirect != "" {
+		p, ok = s.seen(p.Redirect)
+	}
+	s.mu.Unlock()
+	if !ok {
+		return
+	}
+	if p.ETag != "" {
+		r.Header.Set("If-None-Match", p.ETag)
+	}
+	if p.LastModified != "" {
+		r.Header.Set("If-Modified-Since", p.LastModified)
+	}
+}
+
+// Returns a known page and keeps it in the store.
+func (s *PageStore) seen(u string) (storedPage, bool) {
+	p, ok := s.pages[u]
+	if ok {
+		p.Seen = time.Now()
+		s.pages[u] = p
+	}
+	return p, ok
+}
+
+// Returns the content of an unchanged page by its URL after redirects.
+func (s *PageStore) document(u string) document {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	p := s.pages[u]
+	d := document{links: p.Links, base: p.Base, robots: p.Robots}
+	if p.IDs != nil {
+		d.ids = map[string]bool{}
+	}
+	for _, id := range p.IDs {
+		d.ids[id] = true
+	}
+	for _, l := range p.NoFollow {
+		if d.nofollow == nil {
+			d.nofollow = map[string]bool{}
+		}
+		d.nofollow[l] = true
+	}
+	return d
+}
+
+// Remember a page requested as u if the response allows conditional requests.
+// Pages without validators are removed.
+func (s *PageStore) set(u string, r *http.Response, d document) {
+	if s == nil {
+		return
+	}
+	final := u
+	if r.Request != nil {
+		final = r.Request.URL.String()
+	}
+	etag := r.Header.Get("ETag")
+	modified := r.Header.Get("Last-Modified")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if etag == "" && modified == "" {
+		delete(s.pages, u)
+		delete(s.pages, final)
+		return
+	}
+	if s.pages == nil {
+		s.pages = map[string]storedPage{}
+	}
+	now := time.Now()
+	if final != u {
+		s.pages[u] = storedPage{Redirect: final, Seen: now}
+	}
+	var ids []string
+	if d.ids != nil {
+		ids = make([]string, 0, len(d.ids))
+	}
+	for id := range d.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	var nofollow []string
+	for l := range d.nofollow {
+		nofollow = append(nofollow, l)
+	}
+	sort.Strings(nofollow)
+	s.pages[final] = storedPage{ETag: etag, LastModified: modified, Links: d.links, IDs: ids, Base: d.base, Robots: d.robots, NoFollow: nofollow, Seen: now}
+}
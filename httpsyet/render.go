@@ -0,0 +1,385 @@
+package httpsyet
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Defaults of RenderOptions.
+const (
+	DefaultRenderWait    = time.Second
+	DefaultRenderTimeout = 30 * time.Second
+)
+
+// RenderOptions configure the Fetcher returned by NewRenderer.
+type RenderOptions struct {
+	Endpoint string        // Required. DevTools HTTP endpoint of a running browser like http://localhost:9222.
+	Fetcher  Fetcher       // Optional. Makes all requests not rendered by the browser. Defaults to http.DefaultClient.
+	Wait     time.Duration // Optional. Time given to scripts after the page loaded. Defaults to DefaultRenderWait.
+	Timeout  time.Duration // Optional. Limit for rendering a page. Defaults to DefaultRenderTimeout.
+}
+
+// Context key for collecting the requests a rendered page made.
+// The crawler checks them like links.
+type renderedKey struct{}
+
+// Requests made by a rendered page.
+type renderedRequests struct {
+	urls []string
+}
+
+// Context key for requests checking external links which never need rendering.
+type probeKey struct{}
+
+// NewRenderer returns a Fetcher rendering HTML pages in a headless browser
+// using the Chrome DevTools protocol.
+// The rendered DOM is returned instead of the HTML sent by the server
+// so links added by scripts are found.
+// All requests made by the page, including http:// ones blocked as mixed content,
+// are checked like links.
+//
+// Pages are requested with the Fetcher first;
+// only successful HTML responses are loaded in the browser.
+// Status and headers of the first response are kept.
+// Credentials added to requests are not passed on to the browser.
+func NewRenderer(o RenderOptions) (Fetcher, error) {
+	if o.Endpoint == "" {
+		return nil, errors.New("no DevTools endpoint given")
+	}
+	u, err := url.Parse(o.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid DevTools endpoint '%s': expected URL like http://localhost:9222", o.Endpoint)
+	}
+	if o.Fetcher == nil {
+		o.Fetcher = FetcherFunc(http.DefaultClient.Do)
+	}
+	return renderer{o: o, endpoint: strings.TrimSuffix(o.Endpoint, "/")}, nil
+}
+
+type renderer struct {
+	o        RenderOptions
+	endpoint string
+}
+
+func (rd renderer) Fetch(r *http.Request) (*http.Response, error) {
+	res, err := rd.o.Fetcher.Fetch(r)
+	if err != nil || r.Method != http.MethodGet || r.Context().Value(probeKey{}) != nil || res.StatusCode != http.StatusOK {
+		return res, err
+	}
+	mediaType, _, _ := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return res, nil
+	}
+	discard(res)
+
+	ctx, cancel := context.WithTimeout(r.Context(), ttl(rd.o.Timeout, DefaultRenderTimeout))
+	defer cancel()
+	u := r.URL
+	if res.Request != nil {
+		u = res.Request.URL
+	}
+	page, requests, err := rd.render(ctx, u.String())
+	if err != nil {
+		return nil, fmt.Errorf("failed to render: %v", err)
+	}
+	if rr, ok := r.Context().Value(renderedKey{}).(*renderedRequests); ok {
+		rr.urls = requests
+	}
+
+	rendered := *res
+	rendered.Header = res.Header.Clone()
+	rendered.Header.Del("Content-Length")
+	rendered.Body = ioutil.NopCloser(strings.NewReader(page))
+	rendered.ContentLength = int64(len(page))
+	return &rendered, nil
+}
+
+// A DevTools target, i.e. a browser tab.
+type devtoolsTarget struct {
+	ID                   string `json:"id"`
+	WebSocketDebuggerURL string `json:"webSocketDebuggerUrl"`
+}
+
+// Load a page in a new tab.
+// Returns the outer HTML of the document and URLs of all http and https requests made by the page.
+func (rd renderer) render(ctx context.Context, u string) (string, []string, error) {
+	var t devtoolsTarget
+	if err := rd.call(ctx, http.MethodPut, "/json/new?about:blank", &t); err != nil {
+		return "", nil, fmt.Errorf("failed to open tab: %v", err)
+	}
+	defer func() {
+		// The tab must be closed even if rendering timed out.
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 5*time.Second)
+		defer cancel()
+		_ = rd.call(ctx, http.MethodGet, "/json/close/"+t.ID, nil)
+	}()
+
+	ws, err := dialWebsocket(ctx, t.WebSocketDebuggerURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to connect to tab: %v", err)
+	}
+	defer ws.close()
+
+	var requests []string
+	seen := map[string]bool{u: true}
+	loaded := false
+	deadline, _ := ctx.Deadline()
+	s := &devtoolsSession{ws: ws, deadline: deadline, onEvent: func(method string, params json.RawMessage) {
+		switch method {
+		case "Page.loadEventFired":
+			loaded = true
+		case "Network.requestWillBeSent":
+			var e struct {
+				Request struct {
+					URL string `json:"url"`
+				} `json:"request"`
+			}
+			if json.Unmarshal(params, &e) != nil || seen[e.Request.URL] || !isAbsolute(e.Request.URL) {
+				return
+			}
+			seen[e.Request.URL] = true
+			requests = append(requests, e.Request.URL)
+		}
+	}}
+
+	for _, m := range []string{"Page.enable", "Network.enable"} {
+		if err := s.call(m, nil, nil); err != nil {
+			return "", nil, err
+		}
+	}
+	var nav struct {
+		ErrorText string `json:"errorText"`
+	}
+	if err := s.call("Page.navigate", map[string]string{"url": u}, &nav); err != nil {
+		return "", nil, err
+	}
+	if nav.ErrorText != "" {
+		return "", nil, errors.New(nav.ErrorText)
+	}
+	if err := s.waitFor(func() bool { return loaded }); err != nil {
+		return "", nil, fmt.Errorf("page did not load: %v", err)
+	}
+	// Give scripts time to add content after loading.
+	if err := s.idle(ttl(rd.o.Wait, DefaultRenderWait)); err != nil {
+		return "", nil, err
+	}
+
+	var res struct {
+		Result struct {
+			Value string `json:"value"`
+		} `json:"result"`
+		ExceptionDetails *struct {
+			Text string `json:"text"`
+		} `json:"exceptionDetails"`
+	}
+	params := map[string]interface{}{"expression": "document.documentElement.outerHTML", "returnByValue": true}
+	if err := s.call("Runtime.evaluate", params, &res); err != nil {
+		return "", nil, err
+	}
+	if res.ExceptionDetails != nil {
+		return "", nil, fmt.Errorf("failed to get document: %s", res.ExceptionDetails.Text)
+	}
+	return res.Result.Value, requests, nil
+}
+
+// Make a request to the HTTP endpoint of the browser.
+func (rd renderer) call(ctx context.Context, method, path string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, rd.endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	r, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer r.Body.Close()
+	if r.StatusCode != http.StatusOK {
+		return fmt.Errorf("HTTP status code %d", r.StatusCode)
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// A connection to a DevTools target.
+// Events received while waiting for responses are passed to onEvent.
+type devtoolsSession struct {
+	ws       *websocket
+	id       int
+	deadline time.Time // Of the whole session.
+	onEvent  func(method string, params json.RawMessage)
+}
+
+type devtoolsMessage struct {
+	ID     int             `json:"id,omitempty"`
+	Method string          `json:"method,omitempty"`
+	Params json.RawMessage `json:"params,omitempty"`
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  *struct {
+		Message string `json:"message"`
+	} `json:"error,omitempty"`
+}
+
+// Call a method and wait for its result.
+func (s *devtoolsSession) call(method string, params, result interface{}) error {
+	s.id++
+	msg := map[string]interface{}{"id": s.id, "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	if err := s.ws.write(b); err != nil {
+		return fmt.Errorf("%s: %v", method, err)
+	}
+	for {
+		m, err := s.next()
+		if err != nil {
+			return fmt.Errorf("%s: %v", method, err)
+		}
+		if m.ID != s.id {
+			continue
+		}
+		if m.Error != nil {
+			return fmt.Errorf("%s: %s", method, m.Error.Message)
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(m.Result, result)
+	}
+}
+
+// Read events until done returns true.
+func (s *devtoolsSession) waitFor(done func() bool) error {
+	for !done() {
+		if _, err := s.next(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read events for duration d.
+func (s *devtoolsSession) idle(d time.Duration) error {
+	deadline := time.Now().Add(d)
+	if !s.deadline.IsZero() && s.deadline.Before(deadline) {
+		deadline = s.deadline
+	}
+	defer func() { _ = s.ws.conn.SetReadDeadline(s.deadline) }()
+	for {
+		_ = s.ws.conn.SetReadDeadline(deadline)
+		_, err := s.next()
+		if err == nil {
+			continue
+		}
+		if errors.Is(err, os.ErrDeadlineExceeded) && time.Now().After(deadline) {
+			return nil
+		}
+		return err
+	}
+}
+
+// Read the next message, passing events to onEvent.
+func (s *devtoolsSession) next() (devtoolsMessage, error) {
+	var m devtoolsMessage
+	b, err := s.ws.read()
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("invalid message: %v", err)
+	}
+	if m.Method != "" && s.onEvent != nil {
+		s.onEvent(m.Method, m.Params)
+	}
+	return m, nil
+}
+
+// Chrome is a headless browser started by StartChrome.
+type Chrome struct {
+	Endpoint string // DevTools HTTP endpoint to use in RenderOptions.
+
+	cmd *exec.Cmd
+	dir string
+}
+
+// Printed by Chrome once the DevTools endpoint is ready.
+var devtoolsListening = regexp.MustCompile(`DevTools listening on ws://([^/\s]+)/`)
+
+// StartChrome runs a locally installed Chrome or Chromium in headless mode.
+// Call Close to stop it.
+func StartChrome(path string) (*Chrome, error) {
+	dir, err := ioutil.TempDir("", "httpsyet-chrome")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create profile directory: %v", err)
+	}
+	cmd := exec.Command(path,
+		"--headless",
+		"--disable-gpu",
+		"--no-first-run",
+		"--no-default-browser-check",
+		"--remote-debugging-address=127.0.0.1",
+		"--remote-debugging-port=0",
+		"--user-data-dir="+dir,
+		"about:blank",
+	)
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to start chrome: %v", err)
+	}
+	c := &Chrome{cmd: cmd, dir: dir}
+
+	addr := make(chan string, 1)
+	go func() {
+		s := bufio.NewScanner(stderr)
+		for s.Scan() {
+			if m := devtoolsListening.FindStringSubmatch(s.Text()); m != nil {
+				addr <- m[1]
+				break
+			}
+		}
+		// Chrome blocks if its output is not read.
+		_, _ = ioutil.ReadAll(stderr)
+		close(addr)
+	}()
+	select {
+	case a, ok := <-addr:
+		if !ok {
+			c.Close()
+			return nil, errors.New("chrome exited without starting DevTools")
+		}
+		c.Endpoint = "http://" + a
+		return c, nil
+	case <-time.After(DefaultRenderTimeout):
+		c.Close()
+		return nil, errors.New("timeout waiting for chrome to start")
+	}
+}
+
+// Close stops the browser and removes its profile.
+func (c *Chrome) Close() error {
+	_ = c.cmd.Process.Kill()
+	_ = c.cmd.Wait()
+	return os.RemoveAll(c.dir)
+}
@@ -0,0 +1,124 @@
+package httpsyet
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+)
+
+// Retry configures how failed requests are repeated.
+// The zero value disables retries.
+type Retry struct {
+	Max        int           // Optional. Retries after the first attempt.
+	Backoff    time.Duration // Optional. Wait before the first retry, doubled for each further retry. Defaults to 1s.
+	MaxBackoff time.Duration // Optional. Limit of a single wait, also applied to Retry-After. Defaults to 30s.
+	Status     []int         // Optional. Status codes to retry. Defaults to DefaultRetryStatus.
+}
+
+// DefaultRetryStatus are the status codes retried if Retry.Status is not set.
+var DefaultRetryStatus = []int{
+	http.StatusRequestTimeout,
+	http.StatusTooManyRequests,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// Make a request and retry it according to c.Retry.
+// The number of attempts is added to attempts.
+func (c Crawler) do(ctx context.Context, req *http.Request, attempts *int) (*http.Response, error) {
+	for i := 0; ; i++ {
+		*attempts++
+		r, err := c.Fetcher.Fetch(req)
+		if i >= c.Retry.Max || !c.Retry.retryable(r, err) {
+			return r, err
+		}
+
+		wait := c.Retry.backoff(i)
+		if r != nil {
+			if after, ok := retryAfter(r.Header.Get("Retry-After")); ok {
+				wait = after
+			}
+		}
+		if max := ttl(c.Retry.MaxBackoff, 30*time.Second); wait > max {
+			wait = max
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			// Give up on canceled crawls but report the last failure.
+			return r, err
+		}
+		if r != nil {
+			discard(r)
+		}
+		c.Progress.addRetry()
+	}
+}
+
+func (r Retry) retryable(resp *http.Response, err error) bool {
+	if err != nil {
+		return retryableError(err)
+	}
+	return r.retryableStatus(resp.StatusCode)
+}
+
+func (r Retry) retryableStatus(status int) bool {
+	statuses := r.Status
+	if statuses == nil {
+		statuses = DefaultRetryStatus
+	}
+	for _, s := range statuses {
+		if status == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Timeouts and dropped connections are retried.
+// Errors like unknown hosts, refused connections or invalid certificates are not.
+func retryableError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE)
+}
+
+// Exponential backoff with jitter between half and the full wait.
+func (r Retry) backoff(retry int) time.Duration {
+	d := ttl(r.Backoff, time.Second) << uint(retry)
+	if d <= 0 {
+		return ttl(r.MaxBackoff, 30*time.Second)
+	}
+	return d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+}
+
+// Parse a Retry-After header given in seconds or as HTTP date.
+func retryAfter(h string) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if s, err := strconv.Atoi(h); err == nil && s >= 0 {
+		return time.Duration(s) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		d := time.Until(t)
+		if d < 0 {
+			d = 0
+		}
+		return d, true
+	}
+	return 0, false
+}
@@ -0,0 +1,196 @@
+package httpsyet
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Modes of a Scope.
+const (
+	ScopeHost   = "host"   // Only the host of the site, including its port.
+	ScopeDomain = "domain" // All hosts of the registrable domain of the site, e.g. example.com, www.example.com and docs.example.com.
+)
+
+// Scope defines which pages belong to a site.
+// They are crawled recursively; all other links are external and only checked.
+type Scope struct {
+	Mode   string   // Optional. ScopeHost or ScopeDomain. Defaults to ScopeHost.
+	Hosts  []string // Optional. Additional internal hosts. Use a leading "*." to include subdomains.
+	Prefix string   // Optional. Only paths starting with this prefix are internal, e.g. "/docs/".
+}
+
+func (s Scope) validate() error {
+	if s.Mode != "" && s.Mode != ScopeHost && s.Mode != ScopeDomain {
+		return fmt.Errorf("unknown scope '%s': expected %s or %s", s.Mode, ScopeHost, ScopeDomain)
+	}
+	if s.Prefix != "" && !strings.HasPrefix(s.Prefix, "/") {
+		return fmt.Errorf("scope prefix '%s' must start with /", s.Prefix)
+	}
+	return nil
+}
+
+// The scope of a site given to the crawler.
+type siteScope struct {
+	Scope
+	root   *url.URL
+	domain string // Registrable domain of root for ScopeDomain.
+}
+
+func newSiteScope(s Scope, root *url.URL, suffixes *PublicSuffixList) *siteScope {
+	ss := &siteScope{Scope: s, root: root}
+	if s.Mode == ScopeDomain {
+		ss.domain = suffixes.registrableDomain(root.Hostname())
+	}
+	return ss
+}
+
+// Reports if u is an internal page.
+func (s *siteScope) contains(u *url.URL) bool {
+	if s.Prefix != "" && !strings.HasPrefix(u.Path, s.Prefix) {
+		return false
+	}
+	if strings.EqualFold(u.Host, s.root.Host) {
+		return true
+	}
+	for _, h := range s.Hosts {
+		if matchHost(h, u) {
+			return true
+		}
+	}
+	if s.domain == "" {
+		return false
+	}
+	host := strings.ToLower(u.Hostname())
+	return host == s.domain || strings.HasSuffix(host, "."+s.domain)
+}
+
+// Hosts without port match any port.
+func matchHost(pattern string, u *url.URL) bool {
+	pattern = strings.ToLower(pattern)
+	host := strings.ToLower(u.Host)
+	if _, _, err := net.SplitHostPort(pattern); err != nil {
+		host = strings.ToLower(u.Hostname())
+	}
+	if strings.HasPrefix(pattern, "*.") {
+		return host == pattern[2:] || strings.HasSuffix(host, pattern[1:])
+	}
+	return host == pattern
+}
+
+// PublicSuffixList holds the rules of the public suffix list (https://publicsuffix.org)
+// to find the registrable domain of a host, e.g. example.co.uk for www.example.co.uk.
+// Only ASCII rules are supported.
+type PublicSuffixList struct {
+	rules map[string]byte // Rule without leading "*." or "!" by kind.
+}
+
+// Kinds of public suffix rules.
+const (
+	suffixNormal    = 1 << iota // com, co.uk
+	suffixWildcard              // *.ck
+	suffixException             // !www.ck
+)
+
+// Locations of the list on Linux distributions.
+var systemSuffixFiles = []string{
+	"/usr/share/publicsuffix/public_suffix_list.dat",
+	"/usr/share/publicsuffix/effective_tld_names.dat",
+}
+
+var (
+	systemSuffixesOnce sync.Once
+	systemSuffixes     *PublicSuffixList
+)
+
+// SystemPublicSuffixList returns the list installed on the system, e.g. by the publicsuffix package.
+// Without a list, it has no rules and ScopeDomain cannot be used.
+func SystemPublicSuffixList() *PublicSuffixList {
+	systemSuffixesOnce.Do(func() {
+		for _, f := range systemSuffixFiles {
+			if l, err := LoadPublicSuffixList(f); err == nil {
+				systemSuffixes = l
+				return
+			}
+		}
+		systemSuffixes = &PublicSuffixList{}
+	})
+	return systemSuffixes
+}
+
+// LoadPublicSuffixList reads a file in the format of public_suffix_list.dat.
+func LoadPublicSuffixList(file string) (*PublicSuffixList, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read public suffix list: %v", err)
+	}
+	defer f.Close()
+	l := &PublicSuffixList{rules: map[string]byte{}}
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "//") {
+			continue
+		}
+		rule := strings.ToLower(fields[0])
+		switch {
+		case strings.HasPrefix(rule, "!"):
+			l.rules[rule[1:]] |= suffixException
+		case strings.HasPrefix(rule, "*."):
+			l.rules[rule[2:]] |= suffixWildcard
+		default:
+			l.rules[rule] |= suffixNormal
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read public suffix list: %v", err)
+	}
+	return l, nil
+}
+
+// Returns the public suffix plus one label.
+// Returns an empty string for IP addresses and hosts which are public suffixes themselves
+// since their subdomains belong to different owners.
+func (l *PublicSuffixList) registrableDomain(host string) string {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if net.ParseIP(host) != nil {
+		return ""
+	}
+	labels := strings.Split(host, ".")
+	n := l.suffixLabels(labels)
+	if n >= len(labels) {
+		return ""
+	}
+	return strings.Join(labels[len(labels)-n-1:], ".")
+}
+
+// Reports if name is a rule of the list like com or co.uk.
+func (l *PublicSuffixList) isSuffix(name string) bool {
+	return l != nil && l.rules[name]&suffixNormal != 0
+}
+
+// Number of labels of the public suffix.
+// The longest matching rule wins, exceptions take precedence over wildcards
+// and hosts matching no rule have a suffix of one label.
+func (l *PublicSuffixList) suffixLabels(labels []string) int {
+	if l != nil {
+		for i := range labels {
+			name := strings.Join(labels[i:], ".")
+			kind := l.rules[name]
+			if kind&suffixException != 0 {
+				return len(labels) - i - 1
+			}
+			if kind&suffixNormal != 0 {
+				return len(labels) - i
+			}
+			if i+1 < len(labels) && l.rules[strings.Join(labels[i+1:], ".")]&suffixWildcard != 0 {
+				return len(labels) - i
+			}
+		}
+	}
+	return 1
+}
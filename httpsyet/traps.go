@@ -0,0 +1,193 @@
+package httpsyet
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Traps configures the detection of crawler traps:
+// URL patterns under which a site generates an infinite number of pages, like calendars.
+// Each heuristic has a cap per pattern. URLs beyond it are not crawled
+// and the pattern is reported once as a Finding with CategoryTrap.
+// The zero value disables detection; a cap of 0 disables its heuristic.
+type Traps struct {
+	Repeats  int // Optional. Consecutive repetitions of path segments like /a/b/a/b/.
+	Queries  int // Optional. Distinct query strings of a path.
+	Sessions int // Optional. URLs only differing in session IDs like ;jsessionid= or ?sid=.
+	Dates    int // Optional. URLs only differing in dates like /2024/01/ or ?month=2024-01.
+}
+
+// DefaultTraps are caps suitable for most sites.
+var DefaultTraps = Traps{Repeats: 2, Queries: 100, Sessions: 1, Dates: 100}
+
+func (t Traps) validate() error {
+	if t.Repeats < 0 || t.Queries < 0 || t.Sessions < 0 || t.Dates < 0 {
+		return errors.New("trap caps cannot be negative")
+	}
+	return nil
+}
+
+// Query parameters and path parameters carrying session IDs.
+var sessionParams = map[string]bool{
+	"sid":        true,
+	"session":    true,
+	"sessionid":  true,
+	"session_id": true,
+	"jsessionid": true,
+	"phpsessid":  true,
+	"cfid":       true,
+	"cftoken":    true,
+	"zenid":      true,
+	"oscsid":     true,
+}
+
+var sessionPathParam = regexp.MustCompile(`(?i);(jsessionid|phpsessid|sid|sessionid)=[^/;?]*`)
+
+// Dates like 2024/01/15, 2024-01 or 20240115.
+var datePattern = regexp.MustCompile(`\b(19|20)\d\d([-/_.](0[1-9]|1[0-2])([-/_.](0[1-9]|[12]\d|3[01]))?|(0[1-9]|1[0-2])(0[1-9]|[12]\d|3[01]))\b`)
+
+// Counts URLs by pattern to detect traps.
+// Not safe for concurrent use; only used by the queue.
+type traps struct {
+	Traps
+	counts   map[string]int  // URLs by heuristic and pattern.
+	reported map[string]bool // Patterns reported as trap.
+}
+
+func newTraps(t Traps) *traps {
+	return &traps{Traps: t, counts: map[string]int{}, reported: map[string]bool{}}
+}
+
+// Counts u against the caps of all heuristics.
+// Returns a description and the pattern of a trap if u exceeds a cap.
+func (t *traps) check(u *url.URL) (trap, pattern string) {
+	if t.Repeats > 0 {
+		segments := pathSegments(u.Path)
+		if n, i, size := repetitions(segments); n > t.Repeats {
+			pattern = u.Scheme + "://" + u.Host + "/" + strings.Join(segments[:i+size], "/")
+			return fmt.Sprintf("path segments of %s repeat more than %d times", pattern, t.Repeats), "repeats " + pattern
+		}
+	}
+
+	// Other heuristics ignore session IDs so they don't use up their caps.
+	stripped, ok := stripSessions(u)
+	if t.Sessions > 0 && ok {
+		if pattern = stripped.String(); t.count("sessions "+pattern) > t.Sessions {
+			return fmt.Sprintf("more than %d URLs only differ in session IDs from %s", t.Sessions, pattern), "sessions " + pattern
+		}
+	}
+	if t.Dates > 0 {
+		s := stripped.String()
+		if pattern = datePattern.ReplaceAllString(s, "{date}"); pattern != s {
+			if t.count("dates "+pattern) > t.Dates {
+				return fmt.Sprintf("more than %d URLs only differ in dates like %s", t.Dates, pattern), "dates " + pattern
+			}
+		}
+	}
+	if t.Queries > 0 && stripped.RawQuery != "" {
+		if pattern = stripped.Scheme + "://" + stripped.Host + stripped.Path; t.count("queries "+pattern) > t.Queries {
+			return fmt.Sprintf("more than %d query strings for %s", t.Queries, pattern), "queries " + pattern
+		}
+	}
+	return "", ""
+}
+
+func (t *traps) count(key string) int {
+	t.counts[key]++
+	return t.counts[key]
+}
+
+// Reports if a trap has not been reported before.
+func (t *traps) first(pattern string) bool {
+	if t.reported[pattern] {
+		return false
+	}
+	t.reported[pattern] = true
+	return true
+}
+
+// Decides if a site is added to the queue.
+// Only internal pages are checked for traps since external ones are not crawled further.
+func (c Crawler) admit(s site) bool {
+	if c.traps == nil || s.Parent == nil || !s.Scope.contains(s.URL) {
+		return true
+	}
+	trap, pattern := c.traps.check(s.URL)
+	if trap == "" {
+		return true
+	}
+	u, parent := s.URL.String(), s.Parent.String()
+	if c.traps.first(pattern) {
+		msg := "crawler trap: " + trap + "; further URLs are not crawled"
+		c.Progress.addError()
+		c.Logger.Warn("crawler trap", "url", u, "parent", parent, "error", msg)
+		c.report(Finding{Category: CategoryTrap, URL: u, Page: parent, Error: msg})
+	}
+	c.Logger.Debug("trap", "url", u, "parent", parent)
+	return false
+}
+
+func pathSegments(p string) []string {
+	var segments []string
+	for _, s := range strings.Split(p, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
+}
+
+// Returns the highest number of consecutive repetitions of a sequence of segments,
+// the index where the sequence starts and its length.
+func repetitions(segments []string) (n, start, size int) {
+	n, size = 1, 1
+	for l := 1; l <= len(segments)/2; l++ {
+		for i := 0; i+2*l <= len(segments); i++ {
+			count := 1
+			for j := i + l; j+l <= len(segments) && equalSegments(segments[i:i+l], segments[j:j+l]); j += l {
+				count++
+			}
+			if count > n {
+				n, start, size = count, i, l
+			}
+		}
+	}
+	return
+}
+
+func equalSegments(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a copy of u without session IDs
+// and whether there were any.
+func stripSessions(u *url.URL) (*url.URL, bool) {
+	stripped := *u
+	found := sessionPathParam.MatchString(u.Path)
+	if found {
+		stripped.Path = sessionPathParam.ReplaceAllString(u.Path, "")
+		stripped.RawPath = ""
+	}
+	if u.RawQuery == "" {
+		return &stripped, found
+	}
+	q := u.Query()
+	for k := range q {
+		if l := strings.ToLower(k); sessionParams[l] || strings.HasPrefix(l, "aspsessionid") {
+			q.Del(k)
+			found = true
+		}
+	}
+	if found {
+		stripped.RawQuery = q.Encode()
+	}
+	return &stripped, found
+}
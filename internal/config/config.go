@@ -0,0 +1,682 @@
+// Package config loads crawl jobs from a JSON configuration file.
+//
+// A file defines one or more jobs:
+//
+//	{
+//	  "jobs": [
+//	    {
+//	      "name": "blog",
+//	      "sites": ["https://blog.example.com"],
+//	      "depth": 3,
+//	      "parallel": 5,
+//	      "delay": "500ms",
+//	      "exclude": ["/tags/"],
+//	      "headers": {"User-Agent": "httpsyet"},
+//	      "auth": [{"host": "blog.example.com", "username": "ci", "password": "${env:BLOG_PASSWORD}"}],
+//	      "notify": ["slack=https://hooks.slack.com/services/..."],
+//	      "schedule": "0 4 1 * *",
+//	      "jitter": "10m",
+//	      "missed": "catchup"
+//	    }
+//	  ]
+//	}
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/schedule"
+)
+
+// Config is the content of a configuration file.
+type Config struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// Job describes how a group of sites is crawled and where results are sent.
+type Job struct {
+	Name       string            `json:"name"`        // Required. Unique name of the job.
+	Sites      []string          `json:"sites"`       // Required. At least one URL.
+	Depth      int               `json:"depth"`       // Optional. Limit depth. Set to >= 1.
+	Parallel   int               `json:"parallel"`    // Optional. Parallel requests.
+	Delay      string            `json:"delay"`       // Optional. Delay between requests, e.g. "1s".
+	Include    []string          `json:"include"`     // Optional. Regular expressions of links to follow.
+	Exclude    []string          `json:"exclude"`     // Optional. Regular expressions of links to ignore.
+	Headers    map[string]string `json:"headers"`     // Optional. Sent with every request to the hosts of the sites, never to external links.
+	Auth       []Auth            `json:"auth"`        // Optional. Credentials for hosts of the sites.
+	Probe      string            `json:"probe"`       // Optional. How external links are checked: head or get.
+	Retries    int               `json:"retries"`     // Optional. Retries of requests failing temporarily.
+	Broken     bool              `json:"broken"`      // Optional. Report categorized broken links instead of links to update.
+	Robots     bool              `json:"robots"`      // Optional. Don't crawl pages linked with rel="nofollow" or from pages with robots meta tag nofollow.
+	Scope      *Scope            `json:"scope"`       // Optional. Pages crawled recursively. Defaults to the host of each site.
+	Scopes     map[string]Scope  `json:"scopes"`      // Optional. Scope by site, overriding scope.
+	Budget     *Budget           `json:"budget"`      // Optional. Limits for all sites of the job together.
+	SiteBudget *Budget           `json:"site_budget"` // Optional. Limits for each site of the job.
+	Traps      *Traps            `json:"traps"`       // Optional. Stop crawling URL patterns generating infinite pages like calendars.
+	Backoff    string            `json:"backoff"`     // Optional. Wait before the first retry, e.g. "1s". Doubled for each further retry.
+	Notify     []string          `json:"notify"`      // Optional. Notifiers in the format kind=url.
+	ReportURL  string            `json:"report_url"`  // Optional. Linked in notifications.
+	Schedule   string            `json:"schedule"`    // Optional. Cron expression for the daemon, e.g. "0 4 1 * *".
+	Jitter     string            `json:"jitter"`      // Optional. Maximum random delay of scheduled runs, e.g. "10m".
+	Missed     string            `json:"missed"`      // Optional. What to do with runs missed while the daemon was down: skip or catchup.
+}
+
+// Auth configures credentials for one host.
+// In files read by Load, values of username, password, token, headers and login fields
+// can reference environment variables like ${env:TOKEN}.
+type Auth struct {
+	Host     string            `json:"host"`     // Required. Host of one of the sites, including the port if it is not the default.
+	Username string            `json:"username"` // Optional. User for basic auth.
+	Password string            `json:"password"` // Optional. Password for basic auth.
+	Token    string            `json:"token"`    // Optional. Bearer token.
+	Headers  map[string]string `json:"headers"`  // Optional. Custom headers like API keys.
+	Cookies  string            `json:"cookies"`  // Optional. File in the Netscape cookies.txt format.
+	Login    *Login            `json:"login"`    // Optional. Form submitted before crawling.
+}
+
+// Scope defines which pages belong to a site.
+type Scope struct {
+	Mode   string   `json:"mode"`   // Optional. host or domain (all hosts of the registrable domain). Defaults to host.
+	Hosts  []string `json:"hosts"`  // Optional. Additional internal hosts. Use a leading "*." to include subdomains.
+	Prefix string   `json:"prefix"` // Optional. Only paths starting with this prefix are internal.
+}
+
+func (s Scope) problems(path string) []problem {
+	var ps []problem
+	if s.Mode != "" && s.Mode != httpsyet.ScopeHost && s.Mode != httpsyet.ScopeDomain {
+		ps = append(ps, problem{path: path + ".mode", msg: fmt.Sprintf("unknown scope '%s': expected %s or %s", s.Mode, httpsyet.ScopeHost, httpsyet.ScopeDomain)})
+	}
+	if s.Prefix != "" && !strings.HasPrefix(s.Prefix, "/") {
+		ps = append(ps, problem{path: path + ".prefix", msg: fmt.Sprintf("prefix '%s' must start with /", s.Prefix)})
+	}
+	return ps
+}
+
+func (s Scope) crawlScope() httpsyet.Scope {
+	return httpsyet.Scope{Mode: s.Mode, Hosts: s.Hosts, Prefix: s.Prefix}
+}
+
+// Budget limits how much is crawled.
+type Budget struct {
+	Pages    int64  `json:"pages"`     // Optional. Maximum number of pages and links requested.
+	Bytes    int64  `json:"bytes"`     // Optional. Maximum bytes downloaded from internal pages.
+	PageSize int64  `json:"page_size"` // Optional. Maximum bytes read from a single page.
+	Duration string `json:"duration"`  // Optional. Maximum time spent crawling, e.g. "1h".
+}
+
+func (b Budget) problems(path string) []problem {
+	var ps []problem
+	for _, f := range []struct {
+		name  string
+		value int64
+	}{{"pages", b.Pages}, {"bytes", b.Bytes}, {"page_size", b.PageSize}} {
+		if f.value < 0 {
+			ps = append(ps, problem{path: path + "." + f.name, msg: f.name + " cannot be negative"})
+		}
+	}
+	if b.Duration != "" {
+		if d, err := time.ParseDuration(b.Duration); err != nil {
+			ps = append(ps, problem{path: path + ".duration", msg: fmt.Sprintf("invalid duration '%s'", b.Duration)})
+		} else if d < 0 {
+			ps = append(ps, problem{path: path + ".duration", msg: "duration cannot be negative"})
+		}
+	}
+	return ps
+}
+
+// Call only on validated budgets.
+func (b *Budget) crawlBudget() httpsyet.Budget {
+	if b == nil {
+		return httpsyet.Budget{}
+	}
+	cb := httpsyet.Budget{Pages: b.Pages, Bytes: b.Bytes, PageSize: b.PageSize}
+	if b.Duration != "" {
+		d, err := time.ParseDuration(b.Duration)
+		if err != nil {
+			panic(err)
+		}
+		cb.Duration = d
+	}
+	return cb
+}
+
+// Traps caps the URLs crawled per pattern of a crawler trap.
+// Caps of 0 use the defaults of httpsyet.DefaultTraps and -1 disables a heuristic.
+type Traps struct {
+	Repeats  int `json:"repeats"`  // Optional. Consecutive repetitions of path segments like /a/b/a/b/.
+	Queries  int `json:"queries"`  // Optional. Distinct query strings of a path.
+	Sessions int `json:"sessions"` // Optional. URLs only differing in session IDs.
+	Dates    int `json:"dates"`    // Optional. URLs only differing in dates.
+}
+
+func (t Traps) problems(path string) []problem {
+	var ps []problem
+	for _, f := range []struct {
+		name  string
+		value int
+	}{{"repeats", t.Repeats}, {"queries", t.Queries}, {"sessions", t.Sessions}, {"dates", t.Dates}} {
+		if f.value < -1 {
+			ps = append(ps, problem{path: path + "." + f.name, msg: f.name + " must be -1 to disable it or a positive cap"})
+		}
+	}
+	return ps
+}
+
+func (t *Traps) crawlTraps() httpsyet.Traps {
+	if t == nil {
+		return httpsyet.Traps{}
+	}
+	ct := httpsyet.DefaultTraps
+	for _, f := range []struct {
+		dst *int
+		cap int
+	}{{&ct.Repeats, t.Repeats}, {&ct.Queries, t.Queries}, {&ct.Sessions, t.Sessions}, {&ct.Dates, t.Dates}} {
+		switch {
+		case f.cap > 0:
+			*f.dst = f.cap
+		case f.cap < 0:
+			*f.dst = 0
+		}
+	}
+	return ct
+}
+
+// Login describes a login form.
+type Login struct {
+	URL    string            `json:"url"`    // Required. Form action.
+	Fields map[string]string `json:"fields"` // Required. Form fields like user name and password.
+}
+
+// Error describes a problem in a configuration file.
+type Error struct {
+	File string
+	Line int    // 0 if unknown.
+	Path string // Location in the document, e.g. jobs[0].sites[1].
+	Msg  string
+}
+
+func (e Error) Error() string {
+	var parts []string
+	if e.File != "" && e.Line > 0 {
+		parts = append(parts, fmt.Sprintf("%s:%d", e.File, e.Line))
+	} else if e.File != "" {
+		parts = append(parts, e.File)
+	}
+	if e.Path != "" {
+		parts = append(parts, e.Path)
+	}
+	return strings.Join(append(parts, e.Msg), ": ")
+}
+
+// Errors is a list of problems found in a configuration file.
+type Errors []Error
+
+func (es Errors) Error() string {
+	var lines []string
+	for _, e := range es {
+		lines = append(lines, e.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Load reads, parses and validates a configuration file.
+// All problems are returned as Errors.
+func Load(file string) (Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return Config{}, err
+	}
+	c, err := Parse(file, data)
+	if err != nil {
+		return c, err
+	}
+	c.expandEnv()
+	return c, nil
+}
+
+// Parse and validate a configuration.
+// The file name is only used in error messages.
+func Parse(file string, data []byte) (Config, error) {
+	var c Config
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		e := Error{File: file, Msg: err.Error()}
+		switch err := err.(type) {
+		case *json.SyntaxError:
+			e.Line = line(data, err.Offset)
+		case *json.UnmarshalTypeError:
+			e.Line = line(data, err.Offset)
+			e.Path = index.ReplaceAllString(err.Field, "[$1]")
+			e.Msg = fmt.Sprintf("cannot use %s as %s", err.Value, err.Type)
+		default:
+			// Unknown fields are reported without an offset.
+			if m := unknownField.FindStringSubmatch(err.Error()); m != nil {
+				e.Msg = fmt.Sprintf("unknown field '%s'", m[1])
+				e.Path, e.Line = find(data, m[1])
+			}
+		}
+		return c, Errors{e}
+	}
+
+	pos := positions(data)
+	var errs Errors
+	for _, p := range c.validate() {
+		errs = append(errs, Error{File: file, Line: line(data, pos[p.path]), Path: p.path, Msg: p.msg})
+	}
+	if len(errs) > 0 {
+		return c, errs
+	}
+	return c, nil
+}
+
+// References to environment variables like ${env:TOKEN}.
+var envRef = regexp.MustCompile(`\$\{env:([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+func expandEnv(s string) string {
+	return envRef.ReplaceAllStringFunc(s, func(ref string) string {
+		return os.Getenv(envRef.FindStringSubmatch(ref)[1])
+	})
+}
+
+// Replace references to environment variables in credentials.
+// Only done for files since jobs from other sources must not read the environment.
+func (c *Config) expandEnv() {
+	for i := range c.Jobs {
+		for k := range c.Jobs[i].Auth {
+			a := &c.Jobs[i].Auth[k]
+			a.Username = expandEnv(a.Username)
+			a.Password = expandEnv(a.Password)
+			a.Token = expandEnv(a.Token)
+			for h, v := range a.Headers {
+				a.Headers[h] = expandEnv(v)
+			}
+			if a.Login != nil {
+				for f, v := range a.Login.Fields {
+					a.Login.Fields[f] = expandEnv(v)
+				}
+			}
+		}
+	}
+}
+
+type problem struct {
+	path, msg string
+}
+
+func (c Config) validate() []problem {
+	var ps []problem
+	if len(c.Jobs) == 0 {
+		ps = append(ps, problem{path: "jobs", msg: "no jobs defined"})
+	}
+	names := map[string]bool{}
+	for i, j := range c.Jobs {
+		prefix := fmt.Sprintf("jobs[%d]", i)
+		if j.Name == "" {
+			ps = append(ps, problem{path: prefix, msg: "name is required"})
+		} else if names[j.Name] {
+			ps = append(ps, problem{path: prefix + ".name", msg: fmt.Sprintf("duplicate job name '%s'", j.Name)})
+		}
+		names[j.Name] = true
+
+		for _, p := range j.problems() {
+			if p.path == "" {
+				p.path = prefix
+			} else {
+				p.path = prefix + "." + p.path
+			}
+			ps = append(ps, p)
+		}
+	}
+	return ps
+}
+
+// Validate a single job.
+// Does not require a name.
+func (j Job) Validate() error {
+	var errs Errors
+	for _, p := range j.problems() {
+		errs = append(errs, Error{Path: p.path, Msg: p.msg})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Problems use paths relative to the job.
+func (j Job) problems() []problem {
+	var ps []problem
+	add := func(msg string, path string, args ...interface{}) {
+		ps = append(ps, problem{path: fmt.Sprintf(path, args...), msg: msg})
+	}
+
+	if len(j.Sites) == 0 {
+		add("no sites given", "")
+	}
+	for k, s := range j.Sites {
+		if u, err := url.Parse(s); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(fmt.Sprintf("invalid URL '%s'", s), "sites[%d]", k)
+		}
+	}
+	if j.Depth < 0 {
+		add("depth cannot be negative", "depth")
+	}
+	if j.Parallel < 0 {
+		add("parallel cannot be negative", "parallel")
+	}
+	if j.Delay != "" {
+		if d, err := time.ParseDuration(j.Delay); err != nil {
+			add(fmt.Sprintf("invalid duration '%s'", j.Delay), "delay")
+		} else if d < 0 {
+			add("delay cannot be negative", "delay")
+		}
+	}
+	for k, re := range j.Include {
+		if _, err := regexp.Compile(re); err != nil {
+			add(err.Error(), "include[%d]", k)
+		}
+	}
+	for k, re := range j.Exclude {
+		if _, err := regexp.Compile(re); err != nil {
+			add(err.Error(), "exclude[%d]", k)
+		}
+	}
+	if j.Retries < 0 {
+		add("retries cannot be negative", "retries")
+	}
+	if j.Backoff != "" {
+		if d, err := time.ParseDuration(j.Backoff); err != nil {
+			add(fmt.Sprintf("invalid duration '%s'", j.Backoff), "backoff")
+		} else if d < 0 {
+			add("backoff cannot be negative", "backoff")
+		}
+	}
+	if j.Probe != "" && j.Probe != httpsyet.ProbeHead && j.Probe != httpsyet.ProbeGet {
+		add(fmt.Sprintf("unknown probe strategy '%s': expected %s or %s", j.Probe, httpsyet.ProbeHead, httpsyet.ProbeGet), "probe")
+	}
+	if j.Scope != nil {
+		ps = append(ps, j.Scope.problems("scope")...)
+	}
+	if j.Budget != nil {
+		ps = append(ps, j.Budget.problems("budget")...)
+	}
+	if j.SiteBudget != nil {
+		ps = append(ps, j.SiteBudget.problems("site_budget")...)
+	}
+	if j.Traps != nil {
+		ps = append(ps, j.Traps.problems("traps")...)
+	}
+	for s, scope := range j.Scopes {
+		if !contains(j.Sites, s) {
+			add(fmt.Sprintf("site '%s' is not crawled by the job", s), "scopes.%s", s)
+		}
+		ps = append(ps, scope.problems(fmt.Sprintf("scopes.%s", s))...)
+	}
+	hosts := map[string]bool{}
+	for _, s := range j.Sites {
+		if u, err := url.Parse(s); err == nil {
+			hosts[strings.ToLower(u.Host)] = true
+		}
+	}
+	authHosts := map[string]bool{}
+	for k, a := range j.Auth {
+		host := strings.ToLower(a.Host)
+		if host == "" {
+			add("host is required", "auth[%d]", k)
+		} else if authHosts[host] {
+			add(fmt.Sprintf("duplicate host '%s'", a.Host), "auth[%d].host", k)
+		} else if !hosts[host] {
+			add(fmt.Sprintf("host '%s' is not the host of any site", a.Host), "auth[%d].host", k)
+		}
+		authHosts[host] = true
+		if a.Login != nil {
+			if u, err := url.Parse(a.Login.URL); err != nil || !strings.EqualFold(u.Host, a.Host) {
+				add(fmt.Sprintf("login URL '%s' is not on host '%s'", a.Login.URL, a.Host), "auth[%d].login.url", k)
+			}
+			if len(a.Login.Fields) == 0 {
+				add("no login fields given", "auth[%d].login", k)
+			}
+		}
+	}
+	for k, n := range j.Notify {
+		if _, err := notify.Parse(n, notify.Options{}); err != nil {
+			add(err.Error(), "notify[%d]", k)
+		}
+	}
+	if j.Schedule != "" {
+		if _, err := schedule.Parse(j.Schedule); err != nil {
+			add(err.Error(), "schedule")
+		}
+	}
+	if j.Jitter != "" {
+		if d, err := time.ParseDuration(j.Jitter); err != nil {
+			add(fmt.Sprintf("invalid duration '%s'", j.Jitter), "jitter")
+		} else if d < 0 {
+			add("jitter cannot be negative", "jitter")
+		}
+	}
+	if j.Missed != "" && j.Missed != schedule.Skip && j.Missed != schedule.CatchUp {
+		add(fmt.Sprintf("unknown policy '%s': expected %s or %s", j.Missed, schedule.Skip, schedule.CatchUp), "missed")
+	}
+	return ps
+}
+
+// DelayDuration returns the parsed delay.
+// Returns def if no delay is set.
+// Call only on validated jobs.
+func (j Job) DelayDuration(def time.Duration) time.Duration {
+	if j.Delay == "" {
+		return def
+	}
+	d, err := time.ParseDuration(j.Delay)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// CrawlScope returns the scope of all sites of the job.
+func (j Job) CrawlScope() httpsyet.Scope {
+	if j.Scope == nil {
+		return httpsyet.Scope{}
+	}
+	return j.Scope.crawlScope()
+}
+
+// Budgets returns the limits for all sites together and for each site.
+// Call only on validated jobs.
+func (j Job) Budgets() (all, site httpsyet.Budget) {
+	return j.Budget.crawlBudget(), j.SiteBudget.crawlBudget()
+}
+
+// CrawlTraps returns the caps of crawler traps.
+// Detection is disabled if the job does not configure traps.
+func (j Job) CrawlTraps() httpsyet.Traps {
+	return j.Traps.crawlTraps()
+}
+
+// SiteScopes returns the scopes of single sites.
+func (j Job) SiteScopes() map[string]httpsyet.Scope {
+	if len(j.Scopes) == 0 {
+		return nil
+	}
+	scopes := map[string]httpsyet.Scope{}
+	for s, scope := range j.Scopes {
+		scopes[s] = scope.crawlScope()
+	}
+	return scopes
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// RetryPolicy returns the job's retry settings.
+// Call only on validated jobs.
+func (j Job) RetryPolicy() httpsyet.Retry {
+	r := httpsyet.Retry{Max: j.Retries}
+	if j.Backoff != "" {
+		d, err := time.ParseDuration(j.Backoff)
+		if err != nil {
+			panic(err)
+		}
+		r.Backoff = d
+	}
+	return r
+}
+
+// ScheduledJob returns the job's schedule settings.
+// Returns false if the job has no schedule.
+// Call only on validated jobs.
+func (j Job) ScheduledJob() (schedule.Job, bool) {
+	if j.Schedule == "" {
+		return schedule.Job{}, false
+	}
+	s, err := schedule.Parse(j.Schedule)
+	if err != nil {
+		panic(err)
+	}
+	var jitter time.Duration
+	if j.Jitter != "" {
+		if jitter, err = time.ParseDuration(j.Jitter); err != nil {
+			panic(err)
+		}
+	}
+	return schedule.Job{Name: j.Name, Schedule: s, Jitter: jitter, Missed: j.Missed}, true
+}
+
+// Auths returns the job's credentials.
+func (j Job) Auths() []httpsyet.Auth {
+	var auths []httpsyet.Auth
+	for _, a := range j.Auth {
+		auth := httpsyet.Auth{
+			Host:       a.Host,
+			Username:   a.Username,
+			Password:   a.Password,
+			Token:      a.Token,
+			CookieFile: a.Cookies,
+		}
+		if len(a.Headers) > 0 {
+			auth.Header = http.Header{}
+			for k, v := range a.Headers {
+				auth.Header.Set(k, v)
+			}
+		}
+		if a.Login != nil {
+			fields := url.Values{}
+			for k, v := range a.Login.Fields {
+				fields.Set(k, v)
+			}
+			auth.Login = &httpsyet.FormLogin{URL: a.Login.URL, Fields: fields}
+		}
+		auths = append(auths, auth)
+	}
+	return auths
+}
+
+// IncludeRegexps compiles the include rules.
+// Call only on validated jobs.
+func (j Job) IncludeRegexps() []*regexp.Regexp {
+	return mustCompile(j.Include)
+}
+
+// ExcludeRegexps compiles the exclude rules.
+// Call only on validated jobs.
+func (j Job) ExcludeRegexps() []*regexp.Regexp {
+	return mustCompile(j.Exclude)
+}
+
+func mustCompile(exprs []string) []*regexp.Regexp {
+	var res []*regexp.Regexp
+	for _, e := range exprs {
+		res = append(res, regexp.MustCompile(e))
+	}
+	return res
+}
+
+var index = regexp.MustCompile(`\.(\d+)`)
+
+var unknownField = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// Find the first value with the given key.
+func find(data []byte, key string) (string, int) {
+	var paths []string
+	pos := positions(data)
+	for p := range pos {
+		if p == key || strings.HasSuffix(p, "."+key) {
+			paths = append(paths, p)
+		}
+	}
+	if len(paths) == 0 {
+		return "", 0
+	}
+	sort.Slice(paths, func(i, j int) bool { return pos[paths[i]] < pos[paths[j]] })
+	return paths[0], line(data, pos[paths[0]])
+}
+
+// Return the line number of a byte offset.
+func line(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Map the path of every value in a JSON document to its offset.
+// Paths look like jobs[0].sites[1].
+// Expects a valid document.
+func positions(data []byte) map[string]int64 {
+	pos := map[string]int64{}
+	d := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path string)
+	walk = func(path string) {
+		t, err := d.Token()
+		if err != nil {
+			return
+		}
+		// The offset is right after the token,
+		// which is on the same line as the start of the token.
+		pos[path] = d.InputOffset() - 1
+		switch t {
+		case json.Delim('{'):
+			for d.More() {
+				k, err := d.Token()
+				if err != nil {
+					return
+				}
+				p := fmt.Sprint(k)
+				if path != "" {
+					p = path + "." + p
+				}
+				walk(p)
+			}
+			_, _ = d.Token()
+		case json.Delim('['):
+			for i := 0; d.More(); i++ {
+				walk(fmt.Sprintf("%s[%d]", path, i))
+			}
+			_, _ = d.Token()
+		}
+	}
+	walk("")
+	return pos
+}
@@ -0,0 +1,212 @@
+package config_test
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/config"
+)
+
+func TestLoadEnv(t *testing.T) {
+	t.Setenv("HTTPSYET_TEST_TOKEN", "secret")
+	file := filepath.Join(t.TempDir(), "jobs.json")
+	err := ioutil.WriteFile(file, []byte(`{
+  "jobs": [
+    {
+      "name": "blog",
+      "sites": ["https://blog.example.com"],
+      "auth": [{"host": "blog.example.com", "username": "${env:HTTPSYET_TEST_TOKEN}", "password": "pa$$word", "token": "${env:HTTPSYET_TEST_TOKEN}"}]
+    }
+  ]
+}`), 0600)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	c, err := config.Load(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	a := c.Jobs[0].Auths()[0]
+	if a.Username != "secret" || a.Token != "secret" || a.Password != "pa$$word" {
+		t.Errorf("unexpected credentials: %#v", a)
+	}
+
+	// Only Load expands references, not parsing jobs from other sources.
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	c, err = config.Parse(file, data)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a := c.Jobs[0].Auths()[0]; a.Token != "${env:HTTPSYET_TEST_TOKEN}" {
+		t.Errorf("expected token not to be expanded; got %s", a.Token)
+	}
+}
+
+func TestParse(t *testing.T) {
+	c, err := config.Parse("test.json", []byte(`{
+  "jobs": [
+    {
+      "name": "blog",
+      "sites": ["https://blog.example.com", "http://example.com/news"],
+      "depth": 3,
+      "delay": "500ms",
+      "exclude": ["/tags/"],
+      "headers": {"User-Agent": "httpsyet"},
+      "notify": ["slack=https://hooks.slack.com/services/1"],
+      "traps": {"sessions": -1, "dates": 10}
+    },
+    {
+      "name": "shop",
+      "sites": ["https://shop.example.com"]
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(c.Jobs) != 2 {
+		t.Fatalf("expected two jobs; got %d", len(c.Jobs))
+	}
+	j := c.Jobs[0]
+	if j.Name != "blog" || len(j.Sites) != 2 || j.Depth != 3 || j.Headers["User-Agent"] != "httpsyet" {
+		t.Errorf("unexpected job: %#v", j)
+	}
+	if d := j.DelayDuration(time.Second); d != 500*time.Millisecond {
+		t.Errorf("expected delay of 500ms; got %v", d)
+	}
+	if d := c.Jobs[1].DelayDuration(time.Second); d != time.Second {
+		t.Errorf("expected default delay; got %v", d)
+	}
+	if res := j.ExcludeRegexps(); len(res) != 1 || !res[0].MatchString("https://blog.example.com/tags/go") {
+		t.Errorf("unexpected exclude rules: %v", res)
+	}
+	if traps := j.CrawlTraps(); traps != (httpsyet.Traps{Repeats: 2, Queries: 100, Sessions: 0, Dates: 10}) {
+		t.Errorf("unexpected trap caps: %+v", traps)
+	}
+	if traps := c.Jobs[1].CrawlTraps(); traps != (httpsyet.Traps{}) {
+		t.Errorf("expected traps to be disabled; got %+v", traps)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	tt := []struct {
+		name, data, err string
+	}{
+		{
+			name: "syntax",
+			data: "{\n  \"jobs\": [\n    {\"name\": \"a\",}\n  ]\n}",
+			err:  "test.json:3: invalid character '}' looking for beginning of object key string",
+		},
+		{
+			name: "type",
+			data: "{\n  \"jobs\": [\n    {\n      \"name\": \"a\",\n      \"depth\": \"deep\"\n    }\n  ]\n}",
+			err:  "test.json:5: jobs[0].depth: cannot use string as int",
+		},
+		{
+			name: "unknown field",
+			data: "{\n  \"jobs\": [\n    {\n      \"name\": \"a\",\n      \"dpeth\": 2\n    }\n  ]\n}",
+			err:  "test.json:5: jobs[0].dpeth: unknown field 'dpeth'",
+		},
+		{
+			name: "empty",
+			data: "{}",
+			err:  "test.json:1: jobs: no jobs defined",
+		},
+		{
+			name: "invalid values",
+			data: `{
+  "jobs": [
+    {
+      "name": "a",
+      "sites": ["https://example.com", "example.com"],
+      "depth": -1,
+      "delay": "soon",
+      "exclude": ["("],
+      "notify": ["irc=irc://example.com"]
+    },
+    {
+      "name": "a"
+    }
+  ]
+}`,
+			err: `test.json:5: jobs[0].sites[1]: invalid URL 'example.com'
+test.json:6: jobs[0].depth: depth cannot be negative
+test.json:7: jobs[0].delay: invalid duration 'soon'
+test.json:8: jobs[0].exclude[0]: error parsing regexp: missing closing ): ` + "`(`" + `
+test.json:9: jobs[0].notify[0]: unknown notifier 'irc': expected one of slack, teams, mattermost, discord, webhook
+test.json:12: jobs[1].name: duplicate job name 'a'
+test.json:11: jobs[1]: no sites given`,
+		},
+		{
+			name: "invalid auth",
+			data: `{
+  "jobs": [
+    {
+      "name": "a",
+      "sites": ["https://example.com"],
+      "auth": [
+        {"host": "example.com", "login": {"url": "https://evil.com/login"}},
+        {"host": "example.com"},
+        {"host": "other.com"}
+      ]
+    }
+  ]
+}`,
+			err: `test.json:7: jobs[0].auth[0].login.url: login URL 'https://evil.com/login' is not on host 'example.com'
+test.json:7: jobs[0].auth[0].login: no login fields given
+test.json:8: jobs[0].auth[1].host: duplicate host 'example.com'
+test.json:9: jobs[0].auth[2].host: host 'other.com' is not the host of any site`,
+		},
+		{
+			name: "invalid scope",
+			data: `{
+  "jobs": [
+    {
+      "name": "a",
+      "sites": ["https://example.com"],
+      "scope": {"mode": "subdomain", "prefix": "docs/"},
+      "scopes": {
+        "https://example.com": {"mode": "domain"},
+        "https://other.com": {"hosts": ["*.other.com"]}
+      }
+    }
+  ]
+}`,
+			err: `test.json:6: jobs[0].scope.mode: unknown scope 'subdomain': expected host or domain
+test.json:6: jobs[0].scope.prefix: prefix 'docs/' must start with /
+test.json:9: jobs[0].scopes.https://other.com: site 'https://other.com' is not crawled by the job`,
+		},
+		{
+			name: "invalid traps",
+			data: `{
+  "jobs": [
+    {
+      "name": "a",
+      "sites": ["https://example.com"],
+      "traps": {"dates": -2}
+    }
+  ]
+}`,
+			err: `test.json:6: jobs[0].traps.dates: dates must be -1 to disable it or a positive cap`,
+		},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := config.Parse("test.json", []byte(tc.data))
+			if err == nil {
+				t.Fatalf("expected error; got nil")
+			}
+			if err.Error() != tc.err {
+				t.Errorf("expected error:\n%s\ngot:\n%s", tc.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+// Package metrics collects crawl metrics and exposes them in the Prometheus text format.
+// For more see https://prometheus.io/docs/instrumenting/exposition_formats/.
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Upper bounds of the request duration histogram in seconds.
+var buckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Registry collects metrics of all jobs.
+// It is safe for concurrent use.
+type Registry struct {
+	mu          sync.Mutex
+	running     map[int]run       // By run ID. Jobs can run more than once at the same time.
+	lastRun     int               // ID of the last run started.
+	pages       map[string]int64  // Pages of finished runs.
+	upgradable  map[[2]string]int // By job and site.
+	lastSuccess map[string]time.Time
+	errors      map[string]int64 // By category.
+	durations   map[string]*histogram
+}
+
+type run struct {
+	job      string
+	progress *httpsyet.Progress
+}
+
+type histogram struct {
+	counts []int64 // Per bucket, not cumulative.
+	count  int64
+	sum    float64
+}
+
+func (r *Registry) init() {
+	if r.running == nil {
+		r.running = map[int]run{}
+		r.pages = map[string]int64{}
+		r.upgradable = map[[2]string]int{}
+		r.lastSuccess = map[string]time.Time{}
+		r.errors = map[string]int64{}
+		r.durations = map[string]*histogram{}
+	}
+}
+
+// Start tracking the progress of a run of a job.
+// Returns the ID of the run to be passed to Finish.
+func (r *Registry) Start(job string, p *httpsyet.Progress) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	r.lastRun++
+	r.running[r.lastRun] = run{job: job, progress: p}
+	return r.lastRun
+}
+
+// Finish tracking a run and record its results.
+// The report is only used for successful runs.
+func (r *Registry) Finish(id int, rep report.Report, success bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.init()
+	rn, ok := r.running[id]
+	if !ok {
+		return
+	}
+	job := rn.job
+	r.pages[job] += rn.progress.Stats().Pages
+	delete(r.running, id)
+	if !success {
+		return
+	}
+	r.lastSuccess[job] = time.Now()
+
+	counts := map[string]int{}
+	for _, s := range rep.Sites {
+		counts[hostOf(s)] = 0
+	}
+	for _, l := range rep.Upgrades {
+		counts[hostOf(l.Page)]++
+	}
+	for k := range r.upgradable {
+		if k[0] == job {
+			delete(r.upgradable, k)
+		}
+	}
+	for site, n := range counts {
+		r.upgradable[[2]string{job, site}] = n
+	}
+}
+
+// Instrument a Fetcher to record request durations per host and errors by category.
+func (r *Registry) Instrument(f httpsyet.Fetcher) httpsyet.Fetcher {
+	return httpsyet.FetcherFunc(func(req *http.Request) (*http.Response, error) {
+		u := req.URL.String()
+		start := time.Now()
+		resp, err := f.Fetch(req)
+		d := time.Since(start)
+
+		category := ""
+		if err != nil {
+			category = Category(err)
+		} else if resp.StatusCode >= 500 {
+			category = "5xx"
+		} else if resp.StatusCode >= 400 {
+			category = "4xx"
+		}
+
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.init()
+		if category != "" {
+			r.errors[category]++
+		}
+		h, ok := r.durations[hostOf(u)]
+		if !ok {
+			h = &histogram{counts: make([]int64, len(buckets))}
+			r.durations[hostOf(u)] = h
+		}
+		h.observe(d.Seconds())
+		return resp, err
+	})
+}
+
+func (h *histogram) observe(v float64) {
+	for i, b := range buckets {
+		if v <= b {
+			h.counts[i]++
+			break
+		}
+	}
+	h.count++
+	h.sum += v
+}
+
+// Category of a request error.
+func Category(err error) string {
+	return httpsyet.ErrorCategory(err)
+}
+
+// WriteTo writes all metrics in the Prometheus text format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	var b bytes.Buffer
+	r.mu.Lock()
+	r.init()
+
+	metric(&b, "httpsyet_pages_fetched_total", "counter", "Pages fetched.")
+	pages := map[string]int64{}
+	for job, n := range r.pages {
+		pages[job] = n
+	}
+	// Runs of the same job are added up.
+	running := map[string]httpsyet.Stats{}
+	for _, rn := range r.running {
+		s, st := running[rn.job], rn.progress.Stats()
+		s.Pages += st.Pages
+		s.Queued += st.Queued
+		s.Active += st.Active
+		s.Found += st.Found
+		running[rn.job] = s
+	}
+	for job, s := range running {
+		pages[job] += s.Pages
+	}
+	for _, job := range sortedKeys(pages) {
+		fmt.Fprintf(&b, "httpsyet_pages_fetched_total{job=%q} %d\n", job, pages[job])
+	}
+
+	metric(&b, "httpsyet_queue_length", "gauge", "Pages waiting to be crawled.")
+	for _, job := range sortedKeys(running) {
+		fmt.Fprintf(&b, "httpsyet_queue_length{job=%q} %d\n", job, running[job].Queued)
+	}
+
+	metric(&b, "httpsyet_requests_in_flight", "gauge", "Pages currently crawled.")
+	for _, job := range sortedKeys(running) {
+		fmt.Fprintf(&b, "httpsyet_requests_in_flight{job=%q} %d\n", job, running[job].Active)
+	}
+
+	metric(&b, "httpsyet_found_links", "gauge", "Links found so far by running jobs that can be updated to HTTPS.")
+	for _, job := range sortedKeys(running) {
+		fmt.Fprintf(&b, "httpsyet_found_links{job=%q} %d\n", job, running[job].Found)
+	}
+
+	metric(&b, "httpsyet_upgradable_links", "gauge", "Links that can be updated to HTTPS per site, as of the last successful run.")
+	var keys [][2]string
+	for k := range r.upgradable {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, k := range keys {
+		fmt.Fprintf(&b, "httpsyet_upgradable_links{job=%q,site=%q} %d\n", k[0], k[1], r.upgradable[k])
+	}
+
+	metric(&b, "httpsyet_request_errors_total", "counter", "Failed requests by category.")
+	for _, c := range sortedKeys(r.errors) {
+		fmt.Fprintf(&b, "httpsyet_request_errors_total{category=%q} %d\n", c, r.errors[c])
+	}
+
+	metric(&b, "httpsyet_request_duration_seconds", "histogram", "Duration of requests per host.")
+	for _, host := range sortedKeys(r.durations) {
+		h := r.durations[host]
+		var cumulative int64
+		for i, le := range buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(&b, "httpsyet_request_duration_seconds_bucket{host=%q,le=\"%g\"} %d\n", host, le, cumulative)
+		}
+		fmt.Fprintf(&b, "httpsyet_request_duration_seconds_bucket{host=%q,le=\"+Inf\"} %d\n", host, h.count)
+		fmt.Fprintf(&b, "httpsyet_request_duration_seconds_sum{host=%q} %g\n", host, h.sum)
+		fmt.Fprintf(&b, "httpsyet_request_duration_seconds_count{host=%q} %d\n", host, h.count)
+	}
+
+	metric(&b, "httpsyet_last_success_timestamp_seconds", "gauge", "Time of the last successful run.")
+	for _, job := range sortedKeys(r.lastSuccess) {
+		fmt.Fprintf(&b, "httpsyet_last_success_timestamp_seconds{job=%q} %d\n", job, r.lastSuccess[job].Unix())
+	}
+
+	r.mu.Unlock()
+	return b.WriteTo(w)
+}
+
+// ServeHTTP implements http.Handler to be used as /metrics endpoint.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	_, _ = r.WriteTo(w)
+}
+
+// WriteFile writes all metrics to a file to be picked up by the textfile collector of the node exporter.
+// The file is replaced atomically so the collector never reads partial content.
+func (r *Registry) WriteFile(file string) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".httpsyet-metrics")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := r.WriteTo(tmp); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		return fmt.Errorf("failed to write metrics: %v", err)
+	}
+	return nil
+}
+
+func metric(w io.Writer, name, kind, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hostOf(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	return u.Host
+}
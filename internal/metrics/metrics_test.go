@@ -0,0 +1,124 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/report"
+)
+
+func TestRegistry(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	var reg metrics.Registry
+	f := reg.Instrument(httpsyet.FetcherFunc(http.DefaultClient.Do))
+	for _, u := range []string{s.URL, s.URL + "/404", closed.URL} {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if resp, err := f.Fetch(req); err == nil {
+			resp.Body.Close()
+		}
+	}
+
+	var p httpsyet.Progress
+	run := reg.Start("blog", &p)
+
+	var b bytes.Buffer
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	host := strings.TrimPrefix(s.URL, "http://")
+	expect := []string{
+		"# TYPE httpsyet_pages_fetched_total counter",
+		`httpsyet_pages_fetched_total{job="blog"} 0`,
+		`httpsyet_queue_length{job="blog"} 0`,
+		`httpsyet_requests_in_flight{job="blog"} 0`,
+		`httpsyet_request_errors_total{category="4xx"} 1`,
+		`httpsyet_request_errors_total{category="connection_refused"} 1`,
+		"# TYPE httpsyet_request_duration_seconds histogram",
+		`httpsyet_request_duration_seconds_bucket{host="` + host + `",le="+Inf"} 2`,
+		`httpsyet_request_duration_seconds_count{host="` + host + `"} 2`,
+	}
+	for _, e := range expect {
+		if !strings.Contains(b.String(), e+"\n") {
+			t.Errorf("expected metrics to contain %s; got:\n%s", e, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "httpsyet_last_success_timestamp_seconds{") {
+		t.Errorf("expected no successful runs yet")
+	}
+
+	reg.Finish(run, report.Report{
+		Sites:    []string{"https://blog.com", "https://other.com"},
+		Upgrades: []report.Link{{Page: "https://blog.com/post", URL: "http://external.com"}},
+	}, true)
+
+	dir, err := ioutil.TempDir("", "httpsyet")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "httpsyet.prom")
+	if err := reg.WriteFile(file); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect = []string{
+		`httpsyet_upgradable_links{job="blog",site="blog.com"} 1`,
+		`httpsyet_upgradable_links{job="blog",site="other.com"} 0`,
+		`httpsyet_last_success_timestamp_seconds{job="blog"} `,
+	}
+	for _, e := range expect {
+		if !strings.Contains(string(content), e) {
+			t.Errorf("expected metrics to contain %s; got:\n%s", e, content)
+		}
+	}
+	if strings.Contains(string(content), "httpsyet_queue_length{") {
+		t.Errorf("expected finished job not to report queue length")
+	}
+}
+
+func TestOverlappingRuns(t *testing.T) {
+	var reg metrics.Registry
+	var first, second httpsyet.Progress
+	a := reg.Start("blog", &first)
+	b := reg.Start("blog", &second)
+	reg.Finish(a, report.Report{}, true)
+
+	var out bytes.Buffer
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !strings.Contains(out.String(), `httpsyet_queue_length{job="blog"} 0`+"\n") {
+		t.Errorf("expected second run to still be reported; got:\n%s", out.String())
+	}
+
+	reg.Finish(b, report.Report{}, true)
+	out.Reset()
+	if _, err := reg.WriteTo(&out); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Contains(out.String(), "httpsyet_queue_length{") {
+		t.Errorf("expected finished runs not to report queue length; got:\n%s", out.String())
+	}
+}
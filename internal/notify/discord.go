@@ -0,0 +1,37 @@
+package notify
+
+import (
+	"fmt"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Discord rejects messages with more than 2000 characters.
+// Leave some room for the continuation prefix.
+const discordMaxText = 1950
+
+// Discord posts reports to a Discord webhook.
+// For more see https://discord.com/developers/docs/resources/webhook#execute-webhook.
+type Discord struct {
+	URL       string   // Required. Webhook URL.
+	ReportURL string   // Optional. Linked in the summary.
+	Post      PostFunc // Optional. Defaults to http.Post.
+}
+
+type discordData struct {
+	Content string `json:"content"`
+}
+
+// Notify implements Notifier.
+func (d Discord) Notify(r report.Report) error {
+	texts := Markdown(r, d.ReportURL, discordMaxText)
+	for i, text := range texts {
+		if i > 0 {
+			text = fmt.Sprintf("_continued (%d/%d)_\n%s", i+1, len(texts), text)
+		}
+		if err := postJSON(d.Post, "Discord", d.URL, discordData{Content: text}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,216 @@
+package notify
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"html/template"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Email sends reports via SMTP.
+// The summary is sent as text and HTML with all findings attached as CSV.
+type Email struct {
+	Host      string      // Required. SMTP server host.
+	Port      int         // Optional. Defaults to 587.
+	StartTLS  bool        // Optional. Upgrade the connection using STARTTLS.
+	Username  string      // Optional. If set, authenticate using PLAIN auth.
+	Password  string      // Optional.
+	From      string      // Required. Sender address.
+	To        []string    // Required. At least one recipient.
+	ReportURL string      // Optional. Linked in the summary.
+	TLSConfig *tls.Config // Optional. Used for STARTTLS. Defaults to verifying Host.
+}
+
+// Used for long lines in base64 encoded parts.
+const emailLineLength = 76
+
+var emailHTML = template.Must(template.New("email").Parse(`<!DOCTYPE html>
+<html>
+<body>
+<h2>{{ .Report.Title }}</h2>
+<table>
+{{- range .Report.Categories }}
+<tr><td>{{ .Name }}</td><td>{{ .Count }}</td></tr>
+{{- end }}
+</table>
+{{- with .Report.TopHosts 5 }}
+<h3>Top hosts</h3>
+<ul>
+{{- range . }}
+<li>{{ .Host }} ({{ .Count }})</li>
+{{- end }}
+</ul>
+{{- end }}
+{{- with .ReportURL }}
+<p><a href="{{ . }}">View full report</a></p>
+{{- end }}
+{{- with .Report.Upgrades }}
+<h3>You can change these links to https</h3>
+<ul>
+{{- range . }}
+<li><a href="{{ .URL }}">{{ .URL }}</a> on page <a href="{{ .Page }}">{{ .Page }}</a></li>
+{{- end }}
+</ul>
+{{- end }}
+<p>All findings are attached as CSV.</p>
+</body>
+</html>
+`))
+
+// Notify implements Notifier.
+func (e Email) Notify(r report.Report) error {
+	if e.Host == "" || e.From == "" || len(e.To) == 0 {
+		return fmt.Errorf("email: host, sender and recipients are required")
+	}
+	msg, err := e.message(r, time.Now())
+	if err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	if err := e.send(msg); err != nil {
+		return fmt.Errorf("email: %v", err)
+	}
+	return nil
+}
+
+func (e Email) send(msg []byte) error {
+	port := e.Port
+	if port == 0 {
+		port = 587
+	}
+	c, err := smtp.Dial(net.JoinHostPort(e.Host, strconv.Itoa(port)))
+	if err != nil {
+		return fmt.Errorf("failed to connect: %v", err)
+	}
+	defer c.Close()
+
+	if e.StartTLS {
+		conf := e.TLSConfig
+		if conf == nil {
+			conf = &tls.Config{ServerName: e.Host}
+		}
+		if err := c.StartTLS(conf); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if e.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", e.Username, e.Password, e.Host)); err != nil {
+			return fmt.Errorf("failed to authenticate: %v", err)
+		}
+	}
+	if err := c.Mail(e.From); err != nil {
+		return fmt.Errorf("invalid sender: %v", err)
+	}
+	for _, to := range e.To {
+		if err := c.Rcpt(to); err != nil {
+			return fmt.Errorf("invalid recipient %s: %v", to, err)
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send data: %v", err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return fmt.Errorf("failed to send data: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send data: %v", err)
+	}
+	return c.Quit()
+}
+
+// Create a multipart/mixed message with a multipart/alternative body and a CSV attachment.
+func (e Email) message(r report.Report, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	mixed := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", e.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", r.Title()))
+	fmt.Fprintf(&buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mixed.Boundary())
+
+	var alt bytes.Buffer
+	altWriter := multipart.NewWriter(&alt)
+	text := strings.Join(append([]string{summary(r, e.ReportURL)}, details(r)...), "\n")
+	if err := writePart(altWriter, "text/plain; charset=utf-8", nil, []byte(text)); err != nil {
+		return nil, err
+	}
+	var html bytes.Buffer
+	data := struct {
+		Report    report.Report
+		ReportURL string
+	}{r, e.ReportURL}
+	if err := emailHTML.Execute(&html, data); err != nil {
+		return nil, fmt.Errorf("failed to render html: %v", err)
+	}
+	if err := writePart(altWriter, "text/html; charset=utf-8", nil, html.Bytes()); err != nil {
+		return nil, err
+	}
+	if err := altWriter.Close(); err != nil {
+		return nil, err
+	}
+
+	h := textproto.MIMEHeader{}
+	h.Set("Content-Type", "multipart/alternative; boundary="+altWriter.Boundary())
+	p, err := mixed.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.Write(alt.Bytes()); err != nil {
+		return nil, err
+	}
+
+	var csv bytes.Buffer
+	if err := r.WriteCSV(&csv); err != nil {
+		return nil, err
+	}
+	attachment := textproto.MIMEHeader{}
+	attachment.Set("Content-Disposition", `attachment; filename="httpsyet.csv"`)
+	if err := writePart(mixed, "text/csv; charset=utf-8", attachment, csv.Bytes()); err != nil {
+		return nil, err
+	}
+
+	if err := mixed.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write a base64 encoded part.
+func writePart(w *multipart.Writer, contentType string, h textproto.MIMEHeader, body []byte) error {
+	if h == nil {
+		h = textproto.MIMEHeader{}
+	}
+	h.Set("Content-Type", contentType)
+	h.Set("Content-Transfer-Encoding", "base64")
+	p, err := w.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	return writeBase64(p, body)
+}
+
+func writeBase64(w io.Writer, body []byte) error {
+	enc := base64.StdEncoding.EncodeToString(body)
+	for len(enc) > emailLineLength {
+		if _, err := io.WriteString(w, enc[:emailLineLength]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[emailLineLength:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
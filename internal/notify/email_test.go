@@ -0,0 +1,186 @@
+package notify_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"qvl.io/httpsyet/internal/notify"
+)
+
+// A minimal SMTP server accepting a single message.
+type fakeSMTP struct {
+	l        net.Listener
+	from     string
+	to       []string
+	auth     string
+	data     string
+	finished chan struct{}
+}
+
+func startSMTP(t *testing.T) *fakeSMTP {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &fakeSMTP{l: l, finished: make(chan struct{})}
+	go s.serve(t)
+	return s
+}
+
+func (s *fakeSMTP) port() int {
+	return s.l.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSMTP) serve(t *testing.T) {
+	defer close(s.finished)
+	conn, err := s.l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(l string) {
+		if _, err := conn.Write([]byte(l + "\r\n")); err != nil {
+			t.Errorf("failed to reply: %v", err)
+		}
+	}
+
+	reply("220 localhost ready")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		switch cmd {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			s.auth = line
+			reply("235 authenticated")
+		case "MAIL":
+			s.from = line
+			reply("250 ok")
+		case "RCPT":
+			s.to = append(s.to, line)
+			reply("250 ok")
+		case "DATA":
+			reply("354 go ahead")
+			var data bytes.Buffer
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			s.data = data.String()
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func TestEmail(t *testing.T) {
+	s := startSMTP(t)
+	defer s.l.Close()
+
+	err := notify.Email{
+		Host:      "127.0.0.1",
+		Port:      s.port(),
+		Username:  "user",
+		Password:  "secret",
+		From:      "httpsyet@domain.com",
+		To:        []string{"a@domain.com", "b@domain.com"},
+		ReportURL: "https://reports.com",
+	}.Notify(testReport)
+	noErr(t, err)
+	<-s.finished
+
+	if !strings.HasPrefix(s.from, "MAIL FROM:<httpsyet@domain.com>") {
+		t.Errorf("unexpected sender: %s", s.from)
+	}
+	if len(s.to) != 2 {
+		t.Errorf("expected two recipients; got %v", s.to)
+	}
+	if e := "AUTH PLAIN " + base64.StdEncoding.EncodeToString([]byte("\x00user\x00secret")); s.auth != e {
+		t.Errorf("expected auth %s; got %s", e, s.auth)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(s.data))
+	if err != nil {
+		t.Fatalf("invalid message: %v", err)
+	}
+	if subj := msg.Header.Get("Subject"); subj != "2 links can be updated to HTTPS" {
+		t.Errorf("unexpected subject: %s", subj)
+	}
+
+	parts := map[string]string{}
+	var walk func(contentType string, body []byte)
+	walk = func(contentType string, body []byte) {
+		mt, params, err := mime.ParseMediaType(contentType)
+		noErr(t, err)
+		if !strings.HasPrefix(mt, "multipart/") {
+			parts[mt] = string(body)
+			return
+		}
+		mr := multipart.NewReader(bytes.NewReader(body), params["boundary"])
+		for {
+			p, err := mr.NextPart()
+			if err != nil {
+				return
+			}
+			b, err := ioutil.ReadAll(p)
+			noErr(t, err)
+			if p.Header.Get("Content-Transfer-Encoding") == "base64" {
+				b, err = base64.StdEncoding.DecodeString(strings.Replace(string(b), "\r\n", "", -1))
+				noErr(t, err)
+			}
+			walk(p.Header.Get("Content-Type"), b)
+		}
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	noErr(t, err)
+	walk(msg.Header.Get("Content-Type"), body)
+
+	expect := map[string]string{
+		"text/plain": "- http://external.com on page https://domain.com",
+		"text/html":  `<a href="https://reports.com">View full report</a>`,
+		"text/csv":   "broken,https://domain.com/404,https://domain.com,",
+	}
+	for mt, c := range expect {
+		if !strings.Contains(parts[mt], c) {
+			t.Errorf("expected %s part to contain %s; got:\n%s", mt, c, parts[mt])
+		}
+	}
+}
+
+func TestEmailInvalid(t *testing.T) {
+	err := notify.Email{Host: "127.0.0.1", Port: 1, From: "a@domain.com"}.Notify(testReport)
+	doErr(t, "email: host, sender and recipients are required", err)
+
+	s := startSMTP(t)
+	s.l.Close()
+	<-s.finished
+	err = notify.Email{Host: "127.0.0.1", Port: s.port(), From: "a@domain.com", To: []string{"b@domain.com"}}.Notify(testReport)
+	if err == nil || !strings.HasPrefix(err.Error(), "email: failed to connect: ") {
+		t.Errorf("expected connection error; got %v", err)
+	}
+}
@@ -0,0 +1,299 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Issue trackers supported by Issues.
+const (
+	GitHub = "github"
+	GitLab = "gitlab"
+)
+
+// Ways to group findings into issues.
+const (
+	GroupBySite = "site" // One issue per crawled site.
+	GroupByHost = "host" // One issue per external host.
+)
+
+// Maximum length of issue descriptions, GitHub rejects longer ones.
+const issueMaxBody = 60000
+
+// Issues opens one issue per site or external host listing upgradable links
+// and closes it as soon as a later run finds nothing left.
+// Issues are identified by a marker in their description,
+// which makes repeated runs update existing issues instead of creating new ones.
+// The marker includes the job of the report so jobs sharing a repository
+// only update and close their own issues.
+type Issues struct {
+	Kind    string       // Required. GitHub or GitLab.
+	BaseURL string       // Required. Repository API URL, e.g. https://api.github.com/repos/owner/repo or https://gitlab.com/api/v4/projects/42.
+	Token   string       // Optional. Used to authenticate.
+	Label   string       // Optional. Label for created issues. Defaults to httpsyet.
+	Group   string       // Optional. GroupBySite or GroupByHost. Defaults to GroupBySite.
+	Client  *http.Client // Optional. Defaults to http.DefaultClient.
+}
+
+type issue struct {
+	ID    int
+	Title string
+	Body  string
+	Key   string
+}
+
+var issueMarker = regexp.MustCompile(`<!-- httpsyet:(site|host):(\S+?)(?: job:(\S+))? -->`)
+
+// Validate checks the tracker kind and grouping.
+func (is Issues) Validate() error {
+	if is.Kind != GitHub && is.Kind != GitLab {
+		return fmt.Errorf("issues: unknown tracker '%s': expected %s or %s", is.Kind, GitHub, GitLab)
+	}
+	if is.Group != "" && is.Group != GroupBySite && is.Group != GroupByHost {
+		return fmt.Errorf("issues: unknown grouping '%s': expected %s or %s", is.Group, GroupBySite, GroupByHost)
+	}
+	return nil
+}
+
+// Notify implements Notifier.
+func (is Issues) Notify(r report.Report) error {
+	if err := is.Validate(); err != nil {
+		return err
+	}
+	group := is.Group
+	if group == "" {
+		group = GroupBySite
+	}
+	job := url.QueryEscape(r.Job)
+
+	open, err := is.list()
+	if err != nil {
+		return fmt.Errorf("issues: %v", err)
+	}
+	existing := map[string]issue{}
+	for _, i := range open {
+		if m := issueMarker.FindStringSubmatch(i.Body); m != nil && m[1] == group && m[3] == job {
+			i.Key = m[2]
+			existing[i.Key] = i
+		}
+	}
+
+	findings := map[string][]report.Link{}
+	for _, l := range r.Upgrades {
+		k := hostOf(l.Page)
+		if group == GroupByHost {
+			k = hostOf(l.URL)
+		}
+		findings[k] = append(findings[k], l)
+	}
+
+	keys := make([]string, 0, len(findings))
+	for k := range findings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		title, body := issueContent(group, k, job, findings[k])
+		i, ok := existing[k]
+		if !ok {
+			err = is.create(title, body)
+		} else if i.Title != title || i.Body != body {
+			err = is.update(i.ID, title, body)
+		}
+		if err != nil {
+			return fmt.Errorf("issues: %v", err)
+		}
+	}
+
+	// Only close issues of sites that have been crawled,
+	// other sites might be handled by a different job.
+	crawled := map[string]bool{}
+	for _, s := range r.Sites {
+		crawled[hostOf(s)] = true
+	}
+	for k, i := range existing {
+		if _, ok := findings[k]; ok {
+			continue
+		}
+		if group == GroupBySite && !crawled[k] {
+			continue
+		}
+		if err := is.close(i.ID); err != nil {
+			return fmt.Errorf("issues: %v", err)
+		}
+	}
+
+	return nil
+}
+
+func issueContent(group, key, job string, links []report.Link) (string, string) {
+	noun := "links"
+	if len(links) == 1 {
+		noun = "link"
+	}
+	title := fmt.Sprintf("httpsyet: %d %s on %s can be updated to HTTPS", len(links), noun, key)
+	if group == GroupByHost {
+		title = fmt.Sprintf("httpsyet: %d %s to %s can be updated to HTTPS", len(links), noun, key)
+	}
+
+	marker := fmt.Sprintf("<!-- httpsyet:%s:%s", group, key)
+	if job != "" {
+		marker += " job:" + job
+	}
+	marker = "\n" + marker + " -->\n"
+	body := "The following links can be changed to `https://`:\n\n"
+	for i, l := range links {
+		line := fmt.Sprintf("- [ ] %s on page %s\n", l.URL, l.Page)
+		if len(body)+len(line)+len(marker)+100 > issueMaxBody {
+			body += fmt.Sprintf("\n... and %d more.\n", len(links)-i)
+			break
+		}
+		body += line
+	}
+	body += "\nThis issue is updated automatically and closed once all links are fixed.\n" + marker
+	return title, body
+}
+
+func (is Issues) label() string {
+	if is.Label == "" {
+		return "httpsyet"
+	}
+	return is.Label
+}
+
+// List all open issues with the label, following pagination.
+func (is Issues) list() ([]issue, error) {
+	state := "open"
+	if is.Kind == GitLab {
+		state = "opened"
+	}
+	u := fmt.Sprintf("%s/issues?state=%s&labels=%s&per_page=100", strings.TrimSuffix(is.BaseURL, "/"), state, url.QueryEscape(is.label()))
+
+	var issues []issue
+	for u != "" {
+		resp, err := is.do(http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		var items []struct {
+			Number      int             `json:"number"`
+			IID         int             `json:"iid"`
+			Title       string          `json:"title"`
+			Body        string          `json:"body"`
+			Description string          `json:"description"`
+			PullRequest json.RawMessage `json:"pull_request"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&items)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("invalid response from %s: %v", u, err)
+		}
+		for _, i := range items {
+			// GitHub lists pull requests as issues too.
+			if i.PullRequest != nil {
+				continue
+			}
+			if is.Kind == GitLab {
+				issues = append(issues, issue{ID: i.IID, Title: i.Title, Body: i.Description})
+			} else {
+				issues = append(issues, issue{ID: i.Number, Title: i.Title, Body: i.Body})
+			}
+		}
+		u = nextPage(resp.Header.Get("Link"))
+	}
+	return issues, nil
+}
+
+func (is Issues) create(title, body string) error {
+	var data interface{} = map[string]interface{}{"title": title, "body": body, "labels": []string{is.label()}}
+	if is.Kind == GitLab {
+		data = map[string]interface{}{"title": title, "description": body, "labels": is.label()}
+	}
+	return is.send(http.MethodPost, "/issues", data)
+}
+
+func (is Issues) update(id int, title, body string) error {
+	if is.Kind == GitLab {
+		return is.send(http.MethodPut, fmt.Sprintf("/issues/%d", id), map[string]string{"title": title, "description": body})
+	}
+	return is.send(http.MethodPatch, fmt.Sprintf("/issues/%d", id), map[string]string{"title": title, "body": body})
+}
+
+func (is Issues) close(id int) error {
+	if is.Kind == GitLab {
+		return is.send(http.MethodPut, fmt.Sprintf("/issues/%d", id), map[string]string{"state_event": "close"})
+	}
+	return is.send(http.MethodPatch, fmt.Sprintf("/issues/%d", id), map[string]string{"state": "closed"})
+}
+
+func (is Issues) send(method, path string, data interface{}) error {
+	buf, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("cannot marshal json %#v: %v", data, err)
+	}
+	resp, err := is.do(method, strings.TrimSuffix(is.BaseURL, "/")+path, bytes.NewReader(buf))
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+func (is Issues) do(method, u string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if is.Token != "" {
+		if is.Kind == GitLab {
+			req.Header.Set("PRIVATE-TOKEN", is.Token)
+		} else {
+			req.Header.Set("Authorization", "Bearer "+is.Token)
+		}
+	}
+	client := is.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to %s %s: %v", method, u, err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		defer resp.Body.Close()
+		b, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s %s: HTTP status code is not OK (%d): '%s'", method, u, resp.StatusCode, b)
+	}
+	return resp, nil
+}
+
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// Both GitHub and GitLab use the Link header for pagination.
+func nextPage(link string) string {
+	if m := nextLink.FindStringSubmatch(link); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func hostOf(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	return u.Host
+}
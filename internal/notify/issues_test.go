@@ -0,0 +1,198 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/report"
+)
+
+// An in-memory issue tracker speaking the GitHub or GitLab API.
+type tracker struct {
+	sync.Mutex
+	kind     string
+	issues   []map[string]interface{}
+	requests []string
+}
+
+func (tr *tracker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	tr.Lock()
+	defer tr.Unlock()
+	tr.requests = append(tr.requests, r.Method+" "+r.URL.Path)
+
+	idKey, bodyKey, open := "number", "body", "open"
+	if tr.kind == notify.GitLab {
+		idKey, bodyKey, open = "iid", "description", "opened"
+	}
+
+	if r.URL.Path == "/repo/issues" && r.Method == http.MethodGet {
+		if s := r.URL.Query().Get("state"); s != open {
+			http.Error(w, "unexpected state "+s, http.StatusBadRequest)
+			return
+		}
+		var list []map[string]interface{}
+		for _, i := range tr.issues {
+			if i["state"] == open {
+				list = append(list, i)
+			}
+		}
+		if err := json.NewEncoder(w).Encode(list); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	var data map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&data); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if r.URL.Path == "/repo/issues" && r.Method == http.MethodPost {
+		data[idKey] = float64(len(tr.issues) + 1)
+		data["state"] = open
+		tr.issues = append(tr.issues, data)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+	var id int
+	if _, err := fmt.Sscanf(r.URL.Path, "/repo/issues/%d", &id); err != nil || id < 1 || id > len(tr.issues) {
+		http.NotFound(w, r)
+		return
+	}
+	i := tr.issues[id-1]
+	for k, v := range data {
+		if k == "state_event" && v == "close" {
+			k, v = "state", "closed"
+		}
+		i[k] = v
+	}
+	if i[bodyKey] == nil {
+		http.Error(w, "missing body", http.StatusBadRequest)
+	}
+}
+
+func TestIssues(t *testing.T) {
+	for _, kind := range []string{notify.GitHub, notify.GitLab} {
+		t.Run(kind, func(t *testing.T) {
+			tr := &tracker{kind: kind}
+			s := httptest.NewServer(tr)
+			defer s.Close()
+
+			n := notify.Issues{Kind: kind, BaseURL: s.URL + "/repo/", Token: "secret"}
+			r := report.Report{
+				Sites: []string{"https://domain.com", "https://site.com"},
+				Upgrades: []report.Link{
+					{Page: "https://domain.com", URL: "http://external.com"},
+					{Page: "https://domain.com/sub", URL: "http://external.com/sub"},
+					{Page: "https://site.com", URL: "http://external.com"},
+				},
+			}
+
+			noErr(t, n.Notify(r))
+			if len(tr.issues) != 2 {
+				t.Fatalf("expected two issues; got %d", len(tr.issues))
+			}
+			if title := tr.issues[0]["title"]; title != "httpsyet: 2 links on domain.com can be updated to HTTPS" {
+				t.Errorf("unexpected title: %v", title)
+			}
+
+			// Running again without changes must not modify anything.
+			tr.requests = nil
+			noErr(t, n.Notify(r))
+			if len(tr.requests) != 1 || !strings.HasPrefix(tr.requests[0], "GET ") {
+				t.Errorf("expected only a list request; got %v", tr.requests)
+			}
+
+			// Fixing a link updates the issue, fixing all closes it.
+			r.Upgrades = r.Upgrades[1:2]
+			noErr(t, n.Notify(r))
+			if len(tr.issues) != 2 {
+				t.Fatalf("expected no new issues; got %d", len(tr.issues))
+			}
+			if title := tr.issues[0]["title"]; title != "httpsyet: 1 link on domain.com can be updated to HTTPS" {
+				t.Errorf("unexpected title: %v", title)
+			}
+			if state := tr.issues[1]["state"]; state != "closed" {
+				t.Errorf("expected issue for site.com to be closed; got %v", state)
+			}
+
+			// Issues of sites not crawled in this run are left alone.
+			noErr(t, n.Notify(report.Report{Sites: []string{"https://site.com"}}))
+			if state := tr.issues[0]["state"]; state == "closed" {
+				t.Errorf("expected issue for domain.com to stay open")
+			}
+		})
+	}
+}
+
+func TestIssuesByHost(t *testing.T) {
+	tr := &tracker{kind: notify.GitHub}
+	s := httptest.NewServer(tr)
+	defer s.Close()
+
+	n := notify.Issues{Kind: notify.GitHub, BaseURL: s.URL + "/repo", Group: notify.GroupByHost}
+	noErr(t, n.Notify(report.Report{
+		Upgrades: []report.Link{
+			{Page: "https://domain.com", URL: "http://external.com"},
+			{Page: "https://site.com", URL: "http://external.com/sub"},
+			{Page: "https://site.com", URL: "http://other.com"},
+		},
+	}))
+	if len(tr.issues) != 2 {
+		t.Fatalf("expected two issues; got %d", len(tr.issues))
+	}
+	if title := tr.issues[0]["title"]; title != "httpsyet: 2 links to external.com can be updated to HTTPS" {
+		t.Errorf("unexpected title: %v", title)
+	}
+
+	noErr(t, n.Notify(report.Report{}))
+	for _, i := range tr.issues {
+		if i["state"] != "closed" {
+			t.Errorf("expected issue to be closed: %v", i["title"])
+		}
+	}
+}
+
+func TestIssuesByJob(t *testing.T) {
+	tr := &tracker{kind: notify.GitHub}
+	s := httptest.NewServer(tr)
+	defer s.Close()
+
+	n := notify.Issues{Kind: notify.GitHub, BaseURL: s.URL + "/repo", Group: notify.GroupByHost}
+	noErr(t, n.Notify(report.Report{
+		Job:      "blog",
+		Upgrades: []report.Link{{Page: "https://blog.com", URL: "http://external.com"}},
+	}))
+	noErr(t, n.Notify(report.Report{
+		Job:      "shop",
+		Upgrades: []report.Link{{Page: "https://shop.com", URL: "http://external.com"}},
+	}))
+	if len(tr.issues) != 2 {
+		t.Fatalf("expected an issue per job; got %d", len(tr.issues))
+	}
+
+	// A job without findings only closes its own issues.
+	noErr(t, n.Notify(report.Report{Job: "shop"}))
+	if state := tr.issues[0]["state"]; state == "closed" {
+		t.Errorf("expected issue of job blog to stay open")
+	}
+	if state := tr.issues[1]["state"]; state != "closed" {
+		t.Errorf("expected issue of job shop to be closed; got %v", state)
+	}
+}
+
+func TestIssuesValidate(t *testing.T) {
+	if err := (notify.Issues{Kind: "jira"}).Validate(); err == nil {
+		t.Errorf("expected error for unknown tracker")
+	}
+	if err := (notify.Issues{Kind: notify.GitHub, Group: "page"}).Validate(); err == nil {
+		t.Errorf("expected error for unknown grouping")
+	}
+	noErr(t, notify.Issues{Kind: notify.GitLab}.Validate())
+}
@@ -0,0 +1,81 @@
+package notify
+
+import (
+	"fmt"
+
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/slackhook"
+)
+
+// Number of hosts listed in summaries.
+const topHosts = 5
+
+// Markdown formats a report as markdown split into texts no longer than max characters.
+// Chat services mostly agree on the basic markdown syntax used here.
+func Markdown(r report.Report, reportURL string, max int) []string {
+	return split(append([]string{summary(r, reportURL)}, details(r)...), max)
+}
+
+func summary(r report.Report, reportURL string) string {
+	s := "**" + r.Title() + "**\n"
+	for _, c := range r.Categories() {
+		s += fmt.Sprintf("\n%s: %d", c.Name, c.Count)
+	}
+	if hosts := r.TopHosts(topHosts); len(hosts) > 0 {
+		s += "\n\n**Top hosts**"
+		for _, h := range hosts {
+			s += fmt.Sprintf("\n- %s (%d)", h.Host, h.Count)
+		}
+	}
+	if reportURL != "" {
+		s += fmt.Sprintf("\n\n[View full report](%s)", reportURL)
+	}
+	return s
+}
+
+func details(r report.Report) []string {
+	var lines []string
+	lines = append(lines, section("You can change these links to https", r.Upgrades, r.Other)...)
+	lines = append(lines, section("Broken links", r.Broken, nil)...)
+	lines = append(lines, section("Unreachable links", r.Unreachable, nil)...)
+	lines = append(lines, section("Other errors", nil, r.Errors)...)
+	return lines
+}
+
+func section(heading string, links []report.Link, other []string) []string {
+	if len(links) == 0 && len(other) == 0 {
+		return nil
+	}
+	lines := []string{"\n**" + heading + "**"}
+	for _, l := range links {
+		if l.Page == "" {
+			lines = append(lines, "- "+l.URL)
+		} else {
+			lines = append(lines, fmt.Sprintf("- %s on page %s", l.URL, l.Page))
+		}
+	}
+	for _, o := range other {
+		lines = append(lines, "- "+o)
+	}
+	return lines
+}
+
+// Join lines into texts no longer than max characters.
+// Lines longer than max are truncated.
+// Always returns at least one text.
+func split(lines []string, max int) []string {
+	var texts []string
+	t := ""
+	for _, l := range lines {
+		l = slackhook.Truncate(l, max)
+		if t != "" && len(t)+1+len(l) > max {
+			texts = append(texts, t)
+			t = ""
+		}
+		if t != "" {
+			t += "\n"
+		}
+		t += l
+	}
+	return append(texts, t)
+}
@@ -0,0 +1,36 @@
+package notify
+
+import (
+	"fmt"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Mattermost truncates longer messages.
+const mattermostMaxText = 16000
+
+// Mattermost posts reports to a Mattermost incoming webhook.
+// For more see https://developers.mattermost.com/integrate/webhooks/incoming/.
+type Mattermost struct {
+	URL       string   // Required. Incoming webhook URL.
+	ReportURL string   // Optional. Linked in the summary.
+	Post      PostFunc // Optional. Defaults to http.Post.
+}
+
+type mattermostData struct {
+	Text string `json:"text"`
+}
+
+// Notify implements Notifier.
+func (m Mattermost) Notify(r report.Report) error {
+	texts := Markdown(r, m.ReportURL, mattermostMaxText)
+	for i, text := range texts {
+		if i > 0 {
+			text = fmt.Sprintf("_continued (%d/%d)_\n%s", i+1, len(texts), text)
+		}
+		if err := postJSON(m.Post, "Mattermost", m.URL, mattermostData{Text: text}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+// Package notify sends crawl reports to chat services and webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"text/template"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Notifier sends a report to an external service.
+type Notifier interface {
+	Notify(r report.Report) error
+}
+
+// PostFunc has the same signature as http.Post.
+type PostFunc func(url, contentType string, body io.Reader) (*http.Response, error)
+
+// Options are shared by all notifiers created with Parse.
+type Options struct {
+	ReportURL string             // Optional. Linked in messages if set.
+	Template  *template.Template // Optional. Body of generic webhooks. Defaults to the report as JSON.
+	Post      PostFunc           // Optional. Defaults to http.Post.
+}
+
+// Kinds lists the notifiers supported by Parse.
+var Kinds = []string{"slack", "teams", "mattermost", "discord", "webhook"}
+
+// Parse a notifier specification of the form kind=url.
+func Parse(spec string, o Options) (Notifier, error) {
+	i := strings.Index(spec, "=")
+	if i < 1 || i == len(spec)-1 {
+		return nil, fmt.Errorf("invalid notifier '%s': expected format kind=url", spec)
+	}
+	kind, u := spec[:i], spec[i+1:]
+	switch kind {
+	case "slack":
+		return Slack{Hook: u, ReportURL: o.ReportURL, Post: o.Post}, nil
+	case "teams":
+		return Teams{URL: u, ReportURL: o.ReportURL, Post: o.Post}, nil
+	case "mattermost":
+		return Mattermost{URL: u, ReportURL: o.ReportURL, Post: o.Post}, nil
+	case "discord":
+		return Discord{URL: u, ReportURL: o.ReportURL, Post: o.Post}, nil
+	case "webhook":
+		return Webhook{URL: u, Template: o.Template, Post: o.Post}, nil
+	}
+	return nil, fmt.Errorf("unknown notifier '%s': expected one of %s", kind, strings.Join(Kinds, ", "))
+}
+
+// All calls each notifier and returns all errors combined.
+// A failing notifier does not prevent the others from being called.
+func All(ns []Notifier, r report.Report) error {
+	var errs []error
+	for _, n := range ns {
+		if err := n.Notify(r); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Post JSON data and verify the response status.
+func postJSON(post PostFunc, service, u string, v interface{}) error {
+	buf, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("cannot marshal json %#v: %v", v, err)
+	}
+	return postBody(post, service, u, "application/json", buf)
+}
+
+func postBody(post PostFunc, service, u, contentType string, body []byte) error {
+	if post == nil {
+		post = http.Post
+	}
+	resp, err := post(u, contentType, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to post to %s: %v", service, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response from %s: %v", service, err)
+		}
+		return fmt.Errorf("%s: HTTP status code is not OK (%d): '%s'", service, resp.StatusCode, body)
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package notify_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/report"
+)
+
+var testReport = report.Report{
+	Upgrades: []report.Link{
+		{Page: "https://domain.com", URL: "http://external.com"},
+		{Page: "https://domain.com/sub", URL: "http://external.com/sub"},
+	},
+	Broken: []report.Link{{Page: "https://domain.com", URL: "https://domain.com/404"}},
+}
+
+// Record all request bodies sent to a server.
+func record(t *testing.T, status int) (*httptest.Server, *[]string) {
+	var bodies []string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if c := r.Header.Get("Content-Type"); c != "application/json" {
+			t.Errorf("expected content-type to be application/json; got %s", c)
+		}
+		b, err := ioutil.ReadAll(r.Body)
+		noErr(t, err)
+		bodies = append(bodies, string(b))
+		w.WriteHeader(status)
+	}))
+	return s, &bodies
+}
+
+func TestNotifiers(t *testing.T) {
+	tmpl, err := notify.ParseTemplate("test", `{"title": {{ json .Title }}, "count": {{ len .Upgrades }}}`)
+	noErr(t, err)
+
+	tt := []struct {
+		kind     string
+		status   int
+		contains []string
+	}{
+		{kind: "slack", status: http.StatusOK, contains: []string{`"type":"header"`, `"text":"2 links can be updated to HTTPS"`, "View full report"}},
+		{kind: "teams", status: http.StatusOK, contains: []string{`"@type":"MessageCard"`, `"name":"Broken links","value":"1"`, `"uri":"https://reports.com"`}},
+		{kind: "mattermost", status: http.StatusOK, contains: []string{`"text":"**2 links can be updated to HTTPS**`, "[View full report](https://reports.com)"}},
+		{kind: "discord", status: http.StatusNoContent, contains: []string{`"content":"**2 links can be updated to HTTPS**`, "- external.com (2)"}},
+		{kind: "webhook", status: http.StatusOK, contains: []string{`{"title": "2 links can be updated to HTTPS", "count": 2}`}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.kind, func(t *testing.T) {
+			s, bodies := record(t, tc.status)
+			defer s.Close()
+
+			n, err := notify.Parse(tc.kind+"="+s.URL, notify.Options{ReportURL: "https://reports.com", Template: tmpl})
+			noErr(t, err)
+			noErr(t, n.Notify(testReport))
+
+			if len(*bodies) != 1 {
+				t.Fatalf("expected one request; got %d", len(*bodies))
+			}
+			for _, c := range tc.contains {
+				if !strings.Contains((*bodies)[0], c) {
+					t.Errorf("expected body to contain %s; got:\n%s", c, (*bodies)[0])
+				}
+			}
+		})
+	}
+}
+
+func TestWebhookDefault(t *testing.T) {
+	s, bodies := record(t, http.StatusOK)
+	defer s.Close()
+
+	noErr(t, notify.Webhook{URL: s.URL}.Notify(testReport))
+
+	var r report.Report
+	noErr(t, json.Unmarshal([]byte((*bodies)[0]), &r))
+	if len(r.Upgrades) != 2 || r.Broken[0].URL != "https://domain.com/404" {
+		t.Errorf("unexpected report: %#v", r)
+	}
+}
+
+func TestDiscordSplit(t *testing.T) {
+	s, bodies := record(t, http.StatusNoContent)
+	defer s.Close()
+
+	var r report.Report
+	for i := 0; i < 200; i++ {
+		r.Upgrades = append(r.Upgrades, report.Link{Page: "https://domain.com", URL: "http://external.com/some/long/path"})
+	}
+	noErr(t, notify.Discord{URL: s.URL}.Notify(r))
+
+	if len(*bodies) < 2 {
+		t.Fatalf("expected multiple messages; got %d", len(*bodies))
+	}
+	for _, b := range *bodies {
+		var d struct{ Content string }
+		noErr(t, json.Unmarshal([]byte(b), &d))
+		if len(d.Content) > 2000 {
+			t.Errorf("message too long: %d", len(d.Content))
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	s, _ := record(t, http.StatusBadRequest)
+	defer s.Close()
+
+	failing, err := notify.Parse("mattermost="+s.URL, notify.Options{})
+	noErr(t, err)
+	ok, okBodies := record(t, http.StatusOK)
+	defer ok.Close()
+
+	err = notify.All([]notify.Notifier{failing, notify.Webhook{URL: ok.URL}}, testReport)
+	doErr(t, "Mattermost: HTTP status code is not OK (400): ''", err)
+	if len(*okBodies) != 1 {
+		t.Errorf("expected remaining notifiers to be called")
+	}
+
+	_, err = notify.Parse("irc=irc://server", notify.Options{})
+	doErr(t, "unknown notifier 'irc': expected one of slack, teams, mattermost, discord, webhook", err)
+	_, err = notify.Parse("https://hooks.com", notify.Options{})
+	doErr(t, "invalid notifier 'https://hooks.com': expected format kind=url", err)
+}
+
+func noErr(t *testing.T, err error) {
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func doErr(t *testing.T, msg string, err error) {
+	if err == nil {
+		t.Errorf("expected error(%s); got nil", msg)
+		return
+	}
+	if msg != err.Error() {
+		t.Errorf("expected error message to be:\n%s\ngot:\n%s", msg, err.Error())
+	}
+}
@@ -0,0 +1,30 @@
+package notify
+
+import (
+	"net/http"
+
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/internal/slack"
+	"qvl.io/httpsyet/slackhook"
+)
+
+// Slack posts reports to a Slack incoming webhook using Block Kit.
+type Slack struct {
+	Hook      string   // Required. Incoming webhook URL.
+	ReportURL string   // Optional. Linked in the summary.
+	Post      PostFunc // Optional. Defaults to http.Post.
+}
+
+// Notify implements Notifier.
+func (s Slack) Notify(r report.Report) error {
+	post := s.Post
+	if post == nil {
+		post = http.Post
+	}
+	for _, msg := range slack.Messages(r, s.ReportURL) {
+		if err := slackhook.PostCustom(s.Hook, msg, post); err != nil {
+			return err
+		}
+	}
+	return nil
+}
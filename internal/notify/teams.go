@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"fmt"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Teams rejects messages larger than 28KB.
+const teamsMaxText = 20000
+
+// Teams posts reports to a Microsoft Teams incoming webhook as message cards.
+// For more see https://learn.microsoft.com/microsoftteams/platform/webhooks-and-connectors/how-to/connectors-using.
+type Teams struct {
+	URL       string   // Required. Incoming webhook URL.
+	ReportURL string   // Optional. Linked as an action.
+	Post      PostFunc // Optional. Defaults to http.Post.
+}
+
+type teamsCard struct {
+	Type            string         `json:"@type"`
+	Context         string         `json:"@context"`
+	Summary         string         `json:"summary"`
+	Title           string         `json:"title"`
+	ThemeColor      string         `json:"themeColor,omitempty"`
+	Text            string         `json:"text,omitempty"`
+	Sections        []teamsSection `json:"sections,omitempty"`
+	PotentialAction []teamsAction  `json:"potentialAction,omitempty"`
+}
+
+type teamsSection struct {
+	Facts []teamsFact `json:"facts"`
+}
+
+type teamsFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type teamsAction struct {
+	Type    string        `json:"@type"`
+	Name    string        `json:"name"`
+	Targets []teamsTarget `json:"targets"`
+}
+
+type teamsTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+// Notify implements Notifier.
+func (t Teams) Notify(r report.Report) error {
+	// The summary is sent as facts so only details are needed as text.
+	texts := split(details(r), teamsMaxText)
+
+	for i, text := range texts {
+		card := teamsCard{
+			Type:       "MessageCard",
+			Context:    "https://schema.org/extensions",
+			Summary:    r.Title(),
+			Title:      r.Title(),
+			ThemeColor: "0076D7",
+			Text:       text,
+		}
+		if len(texts) > 1 {
+			card.Title = fmt.Sprintf("%s (%d/%d)", r.Title(), i+1, len(texts))
+		}
+		if i == 0 {
+			var facts []teamsFact
+			for _, c := range r.Categories() {
+				facts = append(facts, teamsFact{Name: c.Name, Value: fmt.Sprint(c.Count)})
+			}
+			card.Sections = []teamsSection{{Facts: facts}}
+			if t.ReportURL != "" {
+				card.PotentialAction = []teamsAction{{
+					Type:    "OpenUri",
+					Name:    "View full report",
+					Targets: []teamsTarget{{OS: "default", URI: t.ReportURL}},
+				}}
+			}
+		}
+		if err := postJSON(t.Post, "Teams", t.URL, card); err != nil {
+			return err
+		}
+	}
+	return nil
+}
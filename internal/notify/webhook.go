@@ -0,0 +1,46 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"text/template"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Webhook posts reports to any URL.
+// The request body is created from a template and sent as JSON.
+type Webhook struct {
+	URL      string             // Required.
+	Template *template.Template // Optional. Executed with the report. Defaults to the report as JSON.
+	Post     PostFunc           // Optional. Defaults to http.Post.
+}
+
+// TemplateFuncs are available in webhook templates.
+// Use json to safely include values in a JSON body:
+//
+//	{"text": {{ json .Title }}, "count": {{ len .Upgrades }}}
+var TemplateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		buf, err := json.Marshal(v)
+		return string(buf), err
+	},
+}
+
+// ParseTemplate parses a webhook body template with TemplateFuncs.
+func ParseTemplate(name, text string) (*template.Template, error) {
+	return template.New(name).Funcs(TemplateFuncs).Parse(text)
+}
+
+// Notify implements Notifier.
+func (w Webhook) Notify(r report.Report) error {
+	if w.Template == nil {
+		return postJSON(w.Post, "webhook", w.URL, r)
+	}
+	var buf bytes.Buffer
+	if err := w.Template.Execute(&buf, r); err != nil {
+		return fmt.Errorf("failed to execute webhook template: %v", err)
+	}
+	return postBody(w.Post, "webhook", w.URL, "application/json", buf.Bytes())
+}
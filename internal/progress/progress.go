@@ -0,0 +1,113 @@
+// Package progress reports the progress of a running crawler on a terminal.
+package progress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+)
+
+// Clears the current line of a terminal.
+const clearLine = "\r\033[K"
+
+// Terminal writes progress to a terminal, updating a status line in place.
+// If the output is not a terminal, a summary line is written periodically instead.
+// Other output should be written via the Terminal to not interfere with the status line.
+type Terminal struct {
+	Out      io.Writer     // Required.
+	TTY      bool          // Optional. Set if Out is a terminal. See IsTerminal.
+	Interval time.Duration // Optional. Defaults to 200ms for terminals and 10s otherwise.
+
+	mu     sync.Mutex
+	status string // Currently displayed status line.
+}
+
+// IsTerminal reports whether f is a character device such as a terminal.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Watch reports progress until ctx is canceled.
+// Writes a final summary before returning.
+func (t *Terminal) Watch(ctx context.Context, p *httpsyet.Progress) {
+	interval := t.Interval
+	if interval == 0 {
+		interval = 10 * time.Second
+		if t.TTY {
+			interval = 200 * time.Millisecond
+		}
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	start := time.Now()
+	last, lastTime := int64(0), start
+	for {
+		select {
+		case <-ctx.Done():
+			s := p.Stats()
+			t.show(summary(s, time.Since(start)), true)
+			return
+		case now := <-ticker.C:
+			s := p.Stats()
+			rate := float64(s.Pages-last) / now.Sub(lastTime).Seconds()
+			last, lastTime = s.Pages, now
+			t.show(status(s, rate), false)
+		}
+	}
+}
+
+// Write implements io.Writer.
+// On terminals the status line is cleared before and redrawn after writing.
+func (t *Terminal) Write(b []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.TTY || t.status == "" {
+		return t.Out.Write(b)
+	}
+	if _, err := io.WriteString(t.Out, clearLine); err != nil {
+		return 0, err
+	}
+	n, err := t.Out.Write(b)
+	if err != nil {
+		return n, err
+	}
+	_, err = io.WriteString(t.Out, t.status)
+	return n, err
+}
+
+func (t *Terminal) show(line string, final bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.TTY {
+		fmt.Fprintln(t.Out, line)
+		return
+	}
+	if final {
+		fmt.Fprintf(t.Out, "%s%s\n", clearLine, line)
+		t.status = ""
+		return
+	}
+	t.status = line
+	fmt.Fprint(t.Out, clearLine+line)
+}
+
+func status(s httpsyet.Stats, rate float64) string {
+	return fmt.Sprintf(
+		"progress: %d pages, %d queued, %d active, %.1f req/s, %d found, %d errors",
+		s.Pages, s.Queued, s.Active, rate, s.Found, s.Errors,
+	)
+}
+
+func summary(s httpsyet.Stats, d time.Duration) string {
+	return fmt.Sprintf(
+		"done: %d pages in %v, %d found, %d errors, %d retries",
+		s.Pages, d.Round(time.Second), s.Found, s.Errors, s.Retries,
+	)
+}
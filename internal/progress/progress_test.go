@@ -0,0 +1,108 @@
+package progress_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/progress"
+)
+
+// Buffer safe for concurrent use.
+type buffer struct {
+	sync.Mutex
+	b bytes.Buffer
+}
+
+func (b *buffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.Write(p)
+}
+
+func (b *buffer) String() string {
+	b.Lock()
+	defer b.Unlock()
+	return b.b.String()
+}
+
+func crawl(t *testing.T, term *progress.Terminal) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			fmt.Fprint(w, `<a href="/a">a</a><a href="/b">b</a><a href="/404">404</a>`)
+		} else if r.URL.Path == "/404" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer s.Close()
+
+	var p httpsyet.Progress
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		term.Watch(ctx, &p)
+		close(done)
+	}()
+
+	err := httpsyet.Crawler{
+		Sites:    []string{s.URL},
+		Out:      ioutil.Discard,
+		Log:      log.New(term, "", 0),
+		Delay:    20 * time.Millisecond,
+		Parallel: 1,
+		Progress: &p,
+	}.Run()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cancel()
+	<-done
+}
+
+func TestLines(t *testing.T) {
+	var out buffer
+	crawl(t, &progress.Terminal{Out: &out, Interval: 10 * time.Millisecond})
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) < 3 {
+		t.Fatalf("expected multiple lines; got:\n%s", out.String())
+	}
+	if !strings.HasPrefix(lines[0], "progress: ") {
+		t.Errorf("expected progress line; got %s", lines[0])
+	}
+	if last := lines[len(lines)-1]; !strings.HasPrefix(last, "done: 4 pages in ") || !strings.HasSuffix(last, ", 0 found, 1 errors, 0 retries") {
+		t.Errorf("unexpected summary: %s", last)
+	}
+	if !strings.Contains(out.String(), "\n404 ") {
+		t.Errorf("expected errors to be written as lines; got:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "\r") {
+		t.Errorf("expected no control characters")
+	}
+}
+
+func TestTTY(t *testing.T) {
+	var out buffer
+	crawl(t, &progress.Terminal{Out: &out, TTY: true, Interval: 10 * time.Millisecond})
+
+	s := out.String()
+	if !strings.Contains(s, "\r\033[Kprogress: ") {
+		t.Errorf("expected status line to be updated in place; got %q", s)
+	}
+	// Errors clear the status line and redraw it afterwards.
+	if !strings.Contains(s, "\r\033[K404 ") {
+		t.Errorf("expected status line to be cleared before errors; got %q", s)
+	}
+	if !strings.HasSuffix(s, "\r\033[Kdone: 4 pages in 0s, 0 found, 1 errors, 0 retries\n") {
+		t.Errorf("unexpected summary; got %q", s)
+	}
+}
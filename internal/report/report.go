@@ -0,0 +1,171 @@
+// Package report parses the output of a crawl into a structured result model
+// that can be shared by all the formatters and notifiers.
+package report
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+
+	"qvl.io/httpsyet/httpsyet"
+)
+
+// Link is a URL and the page it has been found on.
+type Link struct {
+	Page string `json:"page,omitempty"`
+	URL  string `json:"url"`
+}
+
+// Report is the structured result of a crawl.
+type Report struct {
+	Upgrades    []Link   `json:"upgrades"`      // Links that can be changed to HTTPS.
+	Broken      []Link   `json:"broken"`        // Links responding with an error status code.
+	Unreachable []Link   `json:"unreachable"`   // Links that could not be fetched at all.
+	Errors      []string `json:"errors"`        // All other error lines.
+	Other       []string `json:"other"`         // Output lines in an unknown format.
+	Sites       []string `json:"sites"`         // Crawled sites. Not set by Parse.
+	Job         string   `json:"job,omitempty"` // Name of the job if it has one. Not set by Parse.
+
+	Findings   []httpsyet.Finding `json:"findings,omitempty"`   // Categorized broken links if checked. Not set by Parse.
+	Unexplored []string           `json:"unexplored,omitempty"` // Pages not crawled because a budget has been exhausted. Not set by Parse.
+}
+
+// Category is a named count of findings.
+type Category struct {
+	Name  string
+	Count int
+}
+
+// HostCount is the number of upgradable links pointing to a host.
+type HostCount struct {
+	Host  string
+	Count int
+}
+
+var (
+	brokenLine      = regexp.MustCompile(`^\d{3} (\S+)(?: on page (\S+))?$`)
+	unreachableLine = regexp.MustCompile(`^failed to get (\S+?): .*?(?: on page (\S+))?$`)
+)
+
+// Parse the output and error strings written by a crawler.
+func Parse(output, errs string) Report {
+	var r Report
+
+	for _, l := range strings.Split(output, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if parts := strings.Split(l, " "); len(parts) == 2 {
+			r.Upgrades = append(r.Upgrades, Link{Page: parts[0], URL: parts[1]})
+		} else {
+			r.Other = append(r.Other, l)
+		}
+	}
+
+	for _, l := range strings.Split(errs, "\n") {
+		l = strings.TrimSpace(l)
+		if l == "" {
+			continue
+		}
+		if m := brokenLine.FindStringSubmatch(l); m != nil {
+			r.Broken = append(r.Broken, Link{Page: m[2], URL: m[1]})
+		} else if m := unreachableLine.FindStringSubmatch(l); m != nil {
+			r.Unreachable = append(r.Unreachable, Link{Page: m[2], URL: m[1]})
+		} else {
+			r.Errors = append(r.Errors, l)
+		}
+	}
+
+	return r
+}
+
+// Empty reports whether nothing has been found.
+func (r Report) Empty() bool {
+	return len(r.Upgrades)+len(r.Broken)+len(r.Unreachable)+len(r.Errors)+len(r.Other) == 0
+}
+
+// Title summarizes the report in a single line.
+func (r Report) Title() string {
+	switch n := len(r.Upgrades); n {
+	case 0:
+		return "No links to update to HTTPS"
+	case 1:
+		return "1 link can be updated to HTTPS"
+	default:
+		return fmt.Sprintf("%d links can be updated to HTTPS", n)
+	}
+}
+
+// Categories returns the number of findings per category.
+// Categories without findings are included.
+func (r Report) Categories() []Category {
+	return []Category{
+		{Name: "Upgradable links", Count: len(r.Upgrades)},
+		{Name: "Broken links", Count: len(r.Broken)},
+		{Name: "Unreachable links", Count: len(r.Unreachable)},
+		{Name: "Other errors", Count: len(r.Errors)},
+	}
+}
+
+// TopHosts returns the n hosts with the most upgradable links.
+// Hosts with equal counts are sorted by name.
+// Set n to 0 to get all hosts.
+func (r Report) TopHosts(n int) []HostCount {
+	counts := map[string]int{}
+	for _, l := range r.Upgrades {
+		counts[host(l.URL)]++
+	}
+	var hosts []HostCount
+	for h, c := range counts {
+		hosts = append(hosts, HostCount{Host: h, Count: c})
+	}
+	sort.Slice(hosts, func(i, j int) bool {
+		if hosts[i].Count != hosts[j].Count {
+			return hosts[i].Count > hosts[j].Count
+		}
+		return hosts[i].Host < hosts[j].Host
+	})
+	if n > 0 && len(hosts) > n {
+		hosts = hosts[:n]
+	}
+	return hosts
+}
+
+func host(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return s
+	}
+	return u.Host
+}
+
+// WriteCSV writes all findings as CSV with a header row.
+// Columns are category, url, page and message.
+func (r Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	rows := [][]string{{"category", "url", "page", "message"}}
+	for _, l := range r.Upgrades {
+		rows = append(rows, []string{"upgradable", l.URL, l.Page, ""})
+	}
+	for _, l := range r.Broken {
+		rows = append(rows, []string{"broken", l.URL, l.Page, ""})
+	}
+	for _, l := range r.Unreachable {
+		rows = append(rows, []string{"unreachable", l.URL, l.Page, ""})
+	}
+	for _, e := range r.Errors {
+		rows = append(rows, []string{"error", "", "", e})
+	}
+	for _, o := range r.Other {
+		rows = append(rows, []string{"other", "", "", o})
+	}
+	if err := cw.WriteAll(rows); err != nil {
+		return fmt.Errorf("failed to write csv: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package report_test
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"qvl.io/httpsyet/internal/report"
+)
+
+func TestParse(t *testing.T) {
+	out := `https://domain.com http://external.com
+https://domain.com/sub http://external.com/sub
+https://site.com http://other.com/page
+this is invalid
+`
+	errs := `404 https://domain.com/missing on page https://domain.com
+500 https://site.com/broken
+failed to get http://expired.com: dial tcp: lookup expired.com: no such host on page https://site.com
+page https://domain.com: invalid URLs: %zz
+`
+	r := report.Parse(out, errs)
+
+	expect := report.Report{
+		Upgrades: []report.Link{
+			{Page: "https://domain.com", URL: "http://external.com"},
+			{Page: "https://domain.com/sub", URL: "http://external.com/sub"},
+			{Page: "https://site.com", URL: "http://other.com/page"},
+		},
+		Broken: []report.Link{
+			{Page: "https://domain.com", URL: "https://domain.com/missing"},
+			{URL: "https://site.com/broken"},
+		},
+		Unreachable: []report.Link{
+			{Page: "https://site.com", URL: "http://expired.com"},
+		},
+		Errors: []string{"page https://domain.com: invalid URLs: %zz"},
+		Other:  []string{"this is invalid"},
+	}
+	if !reflect.DeepEqual(r, expect) {
+		t.Errorf("expected:\n%#v\ngot:\n%#v", expect, r)
+	}
+
+	hosts := []report.HostCount{{Host: "external.com", Count: 2}, {Host: "other.com", Count: 1}}
+	if h := r.TopHosts(0); !reflect.DeepEqual(h, hosts) {
+		t.Errorf("expected hosts %v; got %v", hosts, h)
+	}
+	if h := r.TopHosts(1); !reflect.DeepEqual(h, hosts[:1]) {
+		t.Errorf("expected hosts %v; got %v", hosts[:1], h)
+	}
+	if r.Empty() {
+		t.Error("expected report not to be empty")
+	}
+	if !report.Parse("\n", " \n").Empty() {
+		t.Error("expected report to be empty")
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	r := report.Report{
+		Upgrades: []report.Link{{Page: "https://domain.com", URL: "http://external.com"}},
+		Broken:   []report.Link{{URL: "https://domain.com/404"}},
+		Errors:   []string{"page https://domain.com: invalid URLs: a, b"},
+	}
+	var buf bytes.Buffer
+	if err := r.WriteCSV(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expect := `category,url,page,message
+upgradable,http://external.com,https://domain.com,
+broken,https://domain.com/404,,
+error,,,"page https://domain.com: invalid URLs: a, b"
+`
+	if buf.String() != expect {
+		t.Errorf("expected:\n%s\ngot:\n%s", expect, buf.String())
+	}
+}
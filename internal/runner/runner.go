@@ -0,0 +1,241 @@
+// Package runner crawls configured jobs and sends their results to notifiers.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/config"
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/report"
+)
+
+// Runner is used as configuration for Run.
+type Runner struct {
+	Out      io.Writer            // Required. Crawler output is written here.
+	Err      io.Writer            // Required. Crawler errors are written here.
+	Notify   []notify.Notifier    // Optional. Called for every job in addition to the job's notifiers.
+	Template *template.Template   // Optional. Body of generic webhooks.
+	Verbose  bool                 // Optional. Write status updates to Err.
+	Logger   *slog.Logger         // Optional. If set, crawler logs are written here instead of Err.
+	Fetcher  httpsyet.Fetcher     // Optional. Makes all requests. The job's headers are added for the hosts of its sites. Defaults to a client with DefaultTimeout.
+	Metrics  *metrics.Registry    // Optional. Records metrics of all runs.
+	Cache    *httpsyet.ProbeCache // Optional. Shared by all runs.
+	Pages    *httpsyet.PageStore  // Optional. Shared by all runs.
+
+	Suffixes *httpsyet.PublicSuffixList // Optional. Used for domain scopes. Defaults to the list installed on the system.
+}
+
+// DefaultTimeout limits requests if a Runner has no Fetcher
+// so a stalled host cannot block a job forever.
+const DefaultTimeout = 30 * time.Second
+
+// Run crawls all sites of a job and sends the results to all notifiers.
+// Returns the report of the crawl, which is incomplete if an error is returned.
+// The Progress is optional.
+func (r Runner) Run(ctx context.Context, j config.Job, p *httpsyet.Progress) (report.Report, error) {
+	name := ""
+	if j.Name != "" {
+		name = fmt.Sprintf("job %s: ", j.Name)
+	}
+
+	notifiers := r.Notify
+	for _, spec := range j.Notify {
+		n, err := notify.Parse(spec, notify.Options{ReportURL: j.ReportURL, Template: r.Template})
+		if err != nil {
+			return report.Report{}, fmt.Errorf("%s%v", name, err)
+		}
+		notifiers = append(notifiers, n)
+	}
+
+	var outBuf, errBuf bytes.Buffer
+	f := r.Fetcher
+	if f == nil {
+		var err error
+		if f, err = httpsyet.NewFetcher(httpsyet.FetchOptions{Timeout: DefaultTimeout}); err != nil {
+			return report.Report{}, fmt.Errorf("%s%v", name, err)
+		}
+	}
+	if auths := withHeaders(j.Auths(), j.Sites, j.Headers); len(auths) > 0 {
+		var err error
+		if f, err = httpsyet.NewAuthFetcher(ctx, f, auths); err != nil {
+			return report.Report{}, fmt.Errorf("%s%v", name, err)
+		}
+	}
+	var run int // ID of the run in the metrics.
+	if r.Metrics != nil {
+		f = r.Metrics.Instrument(f)
+		if p == nil {
+			p = &httpsyet.Progress{}
+		}
+		run = r.Metrics.Start(metricsName(j), p)
+	}
+
+	// The plain log lines are always collected for the report.
+	logger := log.New(io.MultiWriter(r.Err, &errBuf), "", 0)
+	var structured *slog.Logger
+	if r.Logger != nil {
+		logger = log.New(&errBuf, "", 0)
+		l := r.Logger
+		if j.Name != "" {
+			l = l.With("job", j.Name)
+		}
+		structured = slog.New(teeHandler{httpsyet.NewLogHandler(logger, false), l.Handler()})
+	}
+
+	// In broken link mode, findings are written instead of links to update.
+	var out, findings io.Writer = io.MultiWriter(r.Out, &outBuf), nil
+	var findBuf, unexploredBuf bytes.Buffer
+	if j.Broken {
+		out = &outBuf
+		findings = io.MultiWriter(r.Out, &findBuf)
+	}
+
+	budget, siteBudget := j.Budgets()
+	err := httpsyet.Crawler{
+		Sites:    j.Sites,
+		Out:      out,
+		Log:      logger,
+		Logger:   structured,
+		Depth:    j.Depth,
+		Parallel: j.Parallel,
+		Delay:    j.DelayDuration(time.Second),
+		Fetcher:  f,
+		Verbose:  r.Verbose,
+		Include:  j.IncludeRegexps(),
+		Exclude:  j.ExcludeRegexps(),
+		Progress: p,
+		Probe:    j.Probe,
+		Cache:    r.Cache,
+		Pages:    r.Pages,
+		Retry:    j.RetryPolicy(),
+		Findings: findings,
+		Robots:   j.Robots,
+		Scope:    j.CrawlScope(),
+		Scopes:   j.SiteScopes(),
+		Suffixes: r.Suffixes,
+		Traps:    j.CrawlTraps(),
+
+		Budget:     budget,
+		SiteBudget: siteBudget,
+		Unexplored: &unexploredBuf,
+	}.RunContext(ctx)
+
+	rep := report.Parse(outBuf.String(), errBuf.String())
+	rep.Sites = j.Sites
+	rep.Job = j.Name
+	for _, l := range strings.Split(unexploredBuf.String(), "\n") {
+		if l != "" {
+			rep.Unexplored = append(rep.Unexplored, l)
+		}
+	}
+	dec := json.NewDecoder(&findBuf)
+	for dec.More() {
+		var f httpsyet.Finding
+		if err := dec.Decode(&f); err != nil {
+			break
+		}
+		rep.Findings = append(rep.Findings, f)
+	}
+	if r.Metrics != nil {
+		r.Metrics.Finish(run, rep, err == nil)
+	}
+	if err != nil {
+		return rep, fmt.Errorf("%sfailed to crawl: %v", name, err)
+	}
+
+	if err := notify.All(notifiers, rep); err != nil {
+		return rep, fmt.Errorf("%sfailed to send notifications: %v", name, err)
+	}
+	return rep, nil
+}
+
+// Add headers to the credentials of the hosts of sites
+// so they are never sent to external links, like API keys in credentials.
+// Headers of credentials take precedence.
+func withHeaders(auths []httpsyet.Auth, sites []string, headers map[string]string) []httpsyet.Auth {
+	if len(headers) == 0 {
+		return auths
+	}
+	for _, s := range sites {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		i := 0
+		for i < len(auths) && !strings.EqualFold(auths[i].Host, u.Host) {
+			i++
+		}
+		if i == len(auths) {
+			auths = append(auths, httpsyet.Auth{Host: u.Host})
+		}
+		h := http.Header{}
+		for k, v := range headers {
+			h.Set(k, v)
+		}
+		for k, vs := range auths[i].Header {
+			h[k] = vs
+		}
+		auths[i].Header = h
+	}
+	return auths
+}
+
+// Jobs from the command line have no name.
+func metricsName(j config.Job) string {
+	if j.Name == "" {
+		return "default"
+	}
+	return j.Name
+}
+
+// A teeHandler passes records to all handlers that are enabled for them.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithAttrs(attrs)
+	}
+	return hs
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	hs := make(teeHandler, len(t))
+	for i, h := range t {
+		hs[i] = h.WithGroup(name)
+	}
+	return hs
+}
@@ -0,0 +1,64 @@
+package schedule_test
+
+import (
+	"testing"
+	"time"
+
+	"qvl.io/httpsyet/internal/schedule"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, time.January, 14, 10, 30, 15, 0, time.UTC)
+
+	tt := []struct {
+		expr, next string
+	}{
+		{"* * * * *", "2026-01-14 10:31"},
+		{"30 4 * * *", "2026-01-15 04:30"},
+		{"0 0 1 * *", "2026-02-01 00:00"},
+		{"*/15 * * * *", "2026-01-14 10:45"},
+		{"5/20 10 * * *", "2026-01-14 10:45"},
+		{"0 9-17/4 * * *", "2026-01-14 13:00"},
+		{"0 0 * * sun", "2026-01-18 00:00"},
+		{"0 0 * * 7", "2026-01-18 00:00"},
+		{"0 0 * * mon-fri", "2026-01-15 00:00"},
+		{"0 0 * mar *", "2026-03-01 00:00"},
+		{"0 0 13 * fri", "2026-01-16 00:00"},
+		{"0 0 29 2 *", "2028-02-29 00:00"},
+		{"15,45 * * * *", "2026-01-14 10:45"},
+		{"@monthly", "2026-02-01 00:00"},
+		{"@hourly", "2026-01-14 11:00"},
+		{"@every 90m", "2026-01-14 12:00"},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.expr, func(t *testing.T) {
+			s, err := schedule.Parse(tc.expr)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			next := s.Next(from).Format("2006-01-02 15:04")
+			if next != tc.next {
+				t.Errorf("expected %s; got %s", tc.next, next)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tt := []struct{ expr, err string }{
+		{"* * * *", "invalid schedule '* * * *': expected 5 fields"},
+		{"60 * * * *", "invalid schedule '60 * * * *': minute: '60' is out of range 0-59"},
+		{"* * 0 * *", "invalid schedule '* * 0 * *': day of month: '0' is out of range 1-31"},
+		{"* * * foo *", "invalid schedule '* * * foo *': month: invalid value 'foo'"},
+		{"*/0 * * * *", "invalid schedule '*/0 * * * *': minute: invalid step '0'"},
+		{"@every soon", `invalid schedule '@every soon': time: invalid duration "soon"`},
+	}
+	for _, tc := range tt {
+		_, err := schedule.Parse(tc.expr)
+		if err == nil || err.Error() != tc.err {
+			t.Errorf("expected error %s; got %v", tc.err, err)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Policies for runs missed while the daemon was not running.
+const (
+	Skip    = "skip"    // Wait for the next scheduled time.
+	CatchUp = "catchup" // Run once right away.
+)
+
+// Job is run by a Daemon.
+type Job struct {
+	Name     string        // Required. Unique name used to persist the last run.
+	Schedule Schedule      // Required.
+	Jitter   time.Duration // Optional. Random delay added to each run.
+	Missed   string        // Optional. Skip or CatchUp. Defaults to Skip.
+}
+
+// Daemon runs jobs on their schedules.
+// Runs of the same job never overlap.
+// If a run takes longer than the interval, the following activations are skipped.
+type Daemon struct {
+	Jobs  []Job                                        // Required.
+	Run   func(ctx context.Context, name string) error // Required. Called for every activation.
+	Log   *log.Logger                                  // Required. Errors and status updates are reported here.
+	State string                                       // Optional. File to persist last runs in. Without it, missed runs are always skipped.
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// Start runs all jobs until ctx is canceled.
+// Waits for all running jobs to finish before returning.
+func (d *Daemon) Start(ctx context.Context) error {
+	d.last = map[string]time.Time{}
+	if d.State != "" {
+		b, err := ioutil.ReadFile(d.State)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read state: %v", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &d.last); err != nil {
+				return fmt.Errorf("invalid state file %s: %v", d.State, err)
+			}
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, j := range d.Jobs {
+		wg.Add(1)
+		go func(j Job) {
+			defer wg.Done()
+			d.loop(ctx, j)
+		}(j)
+	}
+	wg.Wait()
+	return nil
+}
+
+func (d *Daemon) loop(ctx context.Context, j Job) {
+	now := time.Now()
+	next := j.Schedule.Next(now)
+
+	d.mu.Lock()
+	last, ok := d.last[j.Name]
+	d.mu.Unlock()
+	if ok {
+		if missed := j.Schedule.Next(last); !missed.IsZero() && missed.Before(now) {
+			if j.Missed == CatchUp {
+				d.Log.Printf("job %s: catching up run missed at %v\n", j.Name, missed)
+				next = now
+			} else {
+				d.Log.Printf("job %s: skipping run missed at %v\n", j.Name, missed)
+			}
+		}
+	}
+
+	for {
+		if next.IsZero() {
+			d.Log.Printf("job %s: schedule has no further runs\n", j.Name)
+			return
+		}
+		wait := time.Until(next)
+		if j.Jitter > 0 {
+			wait += time.Duration(rand.Int63n(int64(j.Jitter)))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+
+		start := time.Now()
+		if err := d.Run(ctx, j.Name); err != nil {
+			d.Log.Printf("job %s: %v\n", j.Name, err)
+		}
+		if err := d.save(j.Name, start); err != nil {
+			d.Log.Printf("job %s: %v\n", j.Name, err)
+		}
+		// Skip activations which passed while running.
+		next = j.Schedule.Next(time.Now())
+	}
+}
+
+// Persist the last run of a job.
+// The file is replaced atomically to not lose state on crashes.
+func (d *Daemon) save(name string, t time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.last[name] = t
+	if d.State == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(d.last, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode state: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(d.State), ".httpsyet-state")
+	if err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := os.Rename(tmp.Name(), d.State); err != nil {
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package schedule_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"qvl.io/httpsyet/internal/schedule"
+)
+
+func TestDaemon(t *testing.T) {
+	dir, err := ioutil.TempDir("", "httpsyet")
+	noErr(t, err)
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+
+	// A yearly job which last ran two years ago.
+	old := time.Now().AddDate(-2, 0, 0)
+	b, err := json.Marshal(map[string]time.Time{"yearly-catchup": old, "yearly-skip": old})
+	noErr(t, err)
+	noErr(t, ioutil.WriteFile(state, b, 0600))
+
+	yearly, err := schedule.Parse("@yearly")
+	noErr(t, err)
+
+	var mu sync.Mutex
+	runs := map[string]int{}
+	running := map[string]bool{}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+
+	d := &schedule.Daemon{
+		Jobs: []schedule.Job{
+			{Name: "frequent", Schedule: schedule.Every(10 * time.Millisecond)},
+			{Name: "yearly-catchup", Schedule: yearly, Missed: schedule.CatchUp},
+			{Name: "yearly-skip", Schedule: yearly, Missed: schedule.Skip},
+		},
+		Run: func(ctx context.Context, name string) error {
+			mu.Lock()
+			if running[name] {
+				t.Errorf("overlapping runs of %s", name)
+			}
+			running[name] = true
+			runs[name]++
+			mu.Unlock()
+
+			// Take longer than the interval.
+			time.Sleep(50 * time.Millisecond)
+
+			mu.Lock()
+			running[name] = false
+			mu.Unlock()
+			return nil
+		},
+		Log:   log.New(ioutil.Discard, "", 0),
+		State: state,
+	}
+	noErr(t, d.Start(ctx))
+
+	if n := runs["frequent"]; n < 2 || n > 6 {
+		t.Errorf("expected frequent job to run a few times; got %d", n)
+	}
+	if n := runs["yearly-catchup"]; n != 1 {
+		t.Errorf("expected missed run to be caught up once; got %d", n)
+	}
+	if n := runs["yearly-skip"]; n != 0 {
+		t.Errorf("expected missed run to be skipped; got %d", n)
+	}
+
+	b, err = ioutil.ReadFile(state)
+	noErr(t, err)
+	var last map[string]time.Time
+	noErr(t, json.Unmarshal(b, &last))
+	if !last["yearly-catchup"].After(old) || !last["yearly-skip"].Equal(old) || last["frequent"].IsZero() {
+		t.Errorf("unexpected state: %v", last)
+	}
+}
+
+func noErr(t *testing.T, err error) {
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/config"
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/internal/runner"
+)
+
+// Kinds of events sent to subscribers.
+const (
+	eventResult = "result"
+	eventError  = "error"
+	eventDone   = "done"
+)
+
+type event struct {
+	kind, data string
+}
+
+type job struct {
+	id       string
+	config   config.Job
+	started  time.Time
+	cancel   context.CancelFunc
+	done     chan struct{}
+	progress *httpsyet.Progress
+
+	mu       sync.Mutex
+	state    string
+	err      string
+	finished time.Time
+	events   []event // Results and errors in order of occurrence.
+	subs     map[chan struct{}]struct{}
+	result   report.Report
+}
+
+func (j *job) run(ctx context.Context, r runner.Runner) {
+	rep, err := r.Run(ctx, j.config, j.progress)
+
+	j.mu.Lock()
+	j.finished = time.Now()
+	j.result = rep
+	switch {
+	case ctx.Err() != nil:
+		j.state = Canceled
+	case err != nil:
+		j.state = Failed
+		j.err = err.Error()
+	default:
+		j.state = Done
+	}
+	j.mu.Unlock()
+
+	j.cancel()
+	close(j.done)
+	j.notify()
+}
+
+func (j *job) status() Status {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	s := Status{
+		ID:       j.id,
+		Name:     j.config.Name,
+		Sites:    j.config.Sites,
+		State:    j.state,
+		Error:    j.err,
+		Started:  j.started,
+		Progress: j.progress.Stats(),
+	}
+	if !j.finished.IsZero() {
+		f := j.finished
+		s.Finished = &f
+	}
+	return s
+}
+
+// Returns when the job finished and false if it is still running.
+func (j *job) finishedAt() (time.Time, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.finished, !j.finished.IsZero()
+}
+
+// Record an event and wake up all subscribers.
+func (j *job) add(kind, line string) {
+	j.mu.Lock()
+	j.events = append(j.events, event{kind: kind, data: line})
+	j.mu.Unlock()
+	j.notify()
+}
+
+func (j *job) notify() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for c := range j.subs {
+		select {
+		case c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (j *job) subscribe() chan struct{} {
+	c := make(chan struct{}, 1)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.subs == nil {
+		j.subs = map[chan struct{}]struct{}{}
+	}
+	j.subs[c] = struct{}{}
+	return c
+}
+
+func (j *job) unsubscribe(c chan struct{}) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	delete(j.subs, c)
+}
+
+// Send all past and future events until the job is done or the client disconnects.
+func (j *job) stream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		httpError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	c := j.subscribe()
+	defer j.unsubscribe(c)
+
+	sent := 0
+	for {
+		j.mu.Lock()
+		events := j.events[sent:]
+		finished := j.state != Running
+		j.mu.Unlock()
+
+		for _, e := range events {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.kind, e.data)
+		}
+		sent += len(events)
+
+		if finished {
+			b, _ := json.Marshal(j.status())
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", eventDone, b)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-c:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (j *job) report(w http.ResponseWriter, format string) {
+	j.mu.Lock()
+	state := j.state
+	rep := j.result
+	var lines []string
+	for _, e := range j.events {
+		if e.kind == eventResult {
+			lines = append(lines, e.data)
+		}
+	}
+	j.mu.Unlock()
+
+	if state == Running {
+		httpError(w, http.StatusConflict, "job is still running")
+		return
+	}
+	writeReport(w, rep, lines, format)
+}
+
+// Records every line written as an event.
+type lineWriter struct {
+	j    *job
+	kind string
+}
+
+func (l lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(string(p), "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			l.j.add(l.kind, line)
+		}
+	}
+	return len(p), nil
+}
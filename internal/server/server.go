@@ -0,0 +1,322 @@
+// Package server provides an HTTP API to run crawl jobs.
+//
+// Endpoints:
+//
+//	POST   /jobs              Submit a job. The body is a job as in the config file. Responds with the job status.
+//	GET    /jobs              List the status of all jobs.
+//	GET    /jobs/{id}         Get the status and progress of a job.
+//	DELETE /jobs/{id}         Cancel a job.
+//	GET    /jobs/{id}/events  Stream results and errors as Server-Sent Events.
+//	GET    /jobs/{id}/report  Get the report of a job. Use ?format=text, json or csv.
+//	GET    /metrics           Metrics in the Prometheus text format, if enabled.
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/config"
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/internal/runner"
+)
+
+// States of a job.
+const (
+	Running  = "running"
+	Done     = "done"
+	Failed   = "failed"
+	Canceled = "canceled"
+)
+
+// Limit the size of submitted jobs.
+const maxBody = 1 << 20
+
+// Defaults of Server.KeepJobs and Server.KeepFor.
+const (
+	DefaultKeepJobs = 100
+	DefaultKeepFor  = 24 * time.Hour
+)
+
+// Server runs submitted jobs concurrently.
+// Use it as an http.Handler.
+// Finished jobs are kept for their status and report until a retention limit is reached.
+type Server struct {
+	Notify    []notify.Notifier  // Optional. Called for every job in addition to the job's notifiers.
+	Notifiers []string           // Optional. Notifiers as kind=url which submitted jobs may use. Jobs using others are rejected.
+	Template  *template.Template // Optional. Body of generic webhooks.
+	Fetcher   httpsyet.Fetcher   // Optional. Makes all requests of jobs. Defaults to a client with runner.DefaultTimeout.
+	Metrics   *metrics.Registry  // Optional. If set, metrics are recorded and served at /metrics.
+	KeepJobs  int                // Optional. Number of finished jobs kept. Defaults to DefaultKeepJobs.
+	KeepFor   time.Duration      // Optional. Time finished jobs are kept. Defaults to DefaultKeepFor.
+
+	mu     sync.Mutex
+	jobs   []*job
+	lastID int
+}
+
+// Status of a job.
+type Status struct {
+	ID       string         `json:"id"`
+	Name     string         `json:"name,omitempty"`
+	Sites    []string       `json:"sites"`
+	State    string         `json:"state"`
+	Error    string         `json:"error,omitempty"`
+	Started  time.Time      `json:"started"`
+	Finished *time.Time     `json:"finished,omitempty"`
+	Progress httpsyet.Stats `json:"progress"`
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/metrics" && s.Metrics != nil {
+		s.Metrics.ServeHTTP(w, r)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" || len(parts) > 3 {
+		http.NotFound(w, r)
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			s.list(w)
+		case http.MethodPost:
+			s.submit(w, r)
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodPost)
+		}
+		return
+	}
+
+	j := s.find(parts[1])
+	if j == nil {
+		httpError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	if len(parts) == 2 {
+		switch r.Method {
+		case http.MethodGet:
+			writeJSON(w, http.StatusOK, j.status())
+		case http.MethodDelete:
+			j.cancel()
+			writeJSON(w, http.StatusOK, j.status())
+		default:
+			methodNotAllowed(w, http.MethodGet, http.MethodDelete)
+		}
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		methodNotAllowed(w, http.MethodGet)
+		return
+	}
+	switch parts[2] {
+	case "events":
+		j.stream(w, r)
+	case "report":
+		j.report(w, r.URL.Query().Get("format"))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Wait for all running jobs to finish.
+func (s *Server) Wait() {
+	s.mu.Lock()
+	jobs := append([]*job(nil), s.jobs...)
+	s.mu.Unlock()
+	for _, j := range jobs {
+		<-j.done
+	}
+}
+
+// Cancel all running jobs.
+func (s *Server) Cancel() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		j.cancel()
+	}
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var c config.Job
+	d := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBody))
+	d.DisallowUnknownFields()
+	if err := d.Decode(&c); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+		return
+	}
+	if err := c.Validate(); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: %v", err))
+		return
+	}
+	// Clients must not make the server read its files.
+	for _, a := range c.Auth {
+		if a.Cookies != "" {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: cookie files cannot be used via the API (auth for %s)", a.Host))
+			return
+		}
+	}
+	// Clients must not make the server send requests to arbitrary addresses.
+	for _, n := range c.Notify {
+		if !contains(s.Notifiers, n) {
+			httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid job: notifier '%s' is not configured on the server", n))
+			return
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	s.mu.Lock()
+	s.prune(time.Now())
+	s.lastID++
+	id := strconv.Itoa(s.lastID)
+	// Names identify jobs in notifications and metrics.
+	if c.Name == "" {
+		c.Name = "job-" + id
+	}
+	j := &job{
+		id:       id,
+		config:   c,
+		started:  time.Now(),
+		state:    Running,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+		progress: &httpsyet.Progress{},
+	}
+	s.jobs = append(s.jobs, j)
+	s.mu.Unlock()
+
+	go j.run(ctx, runner.Runner{
+		Out:      lineWriter{j, eventResult},
+		Err:      lineWriter{j, eventError},
+		Notify:   s.Notify,
+		Template: s.Template,
+		Fetcher:  s.Fetcher,
+		Metrics:  s.Metrics,
+	})
+
+	w.Header().Set("Location", "/jobs/"+j.id)
+	writeJSON(w, http.StatusCreated, j.status())
+}
+
+func (s *Server) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	s.prune(time.Now())
+	jobs := append([]*job(nil), s.jobs...)
+	s.mu.Unlock()
+	statuses := []Status{}
+	for _, j := range jobs {
+		statuses = append(statuses, j.status())
+	}
+	writeJSON(w, http.StatusOK, statuses)
+}
+
+func (s *Server) find(id string) *job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, j := range s.jobs {
+		if j.id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+// Remove finished jobs exceeding the retention limits.
+// Running jobs are always kept.
+// Must be called with s.mu held.
+func (s *Server) prune(now time.Time) {
+	keep := s.KeepJobs
+	if keep <= 0 {
+		keep = DefaultKeepJobs
+	}
+	keepFor := s.KeepFor
+	if keepFor <= 0 {
+		keepFor = DefaultKeepFor
+	}
+	var kept []*job
+	finished := 0
+	// Count from the newest job to keep the most recent ones.
+	for i := len(s.jobs) - 1; i >= 0; i-- {
+		j := s.jobs[i]
+		if f, ok := j.finishedAt(); ok {
+			finished++
+			if finished > keep || now.Sub(f) > keepFor {
+				continue
+			}
+		}
+		kept = append(kept, j)
+	}
+	for i, k := 0, len(kept)-1; i < k; i, k = i+1, k-1 {
+		kept[i], kept[k] = kept[k], kept[i]
+	}
+	s.jobs = kept
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func methodNotAllowed(w http.ResponseWriter, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+}
+
+// Formats supported by the report endpoint.
+var formats = map[string]string{
+	"text": "text/plain; charset=utf-8",
+	"json": "application/json",
+	"csv":  "text/csv; charset=utf-8",
+}
+
+func writeReport(w http.ResponseWriter, r report.Report, lines []string, format string) {
+	if format == "" {
+		format = "text"
+	}
+	contentType, ok := formats[format]
+	if !ok {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("unknown format '%s': expected text, json or csv", format))
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	switch format {
+	case "text":
+		for _, l := range lines {
+			fmt.Fprintln(w, l)
+		}
+	case "json":
+		_ = json.NewEncoder(w).Encode(r)
+	case "csv":
+		_ = r.WriteCSV(w)
+	}
+}
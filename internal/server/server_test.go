@@ -0,0 +1,235 @@
+package server_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"qvl.io/httpsyet/httpsyet"
+	"qvl.io/httpsyet/internal/server"
+)
+
+// A site with one link that can be upgraded and one broken link.
+func testSite(t *testing.T) (*httptest.Server, *httptest.Server) {
+	tlsServer := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `<a href="http://%s/page">upgrade</a><a href="/404">broken</a>`, strings.TrimPrefix(tlsServer.URL, "https://"))
+	}))
+	return site, tlsServer
+}
+
+func submit(t *testing.T, api, body string) server.Status {
+	resp, err := http.Post(api+"/jobs", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		b, _ := ioutil.ReadAll(resp.Body)
+		t.Fatalf("expected status 201; got %d: %s", resp.StatusCode, b)
+	}
+	var s server.Status
+	noErr(t, json.NewDecoder(resp.Body).Decode(&s))
+	return s
+}
+
+func get(t *testing.T, u string) (int, string) {
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	noErr(t, err)
+	return resp.StatusCode, string(b)
+}
+
+func TestJob(t *testing.T) {
+	site, tlsServer := testSite(t)
+	defer site.Close()
+	defer tlsServer.Close()
+
+	s := &server.Server{Fetcher: httpsyet.FetcherFunc(tlsServer.Client().Do)}
+	api := httptest.NewServer(s)
+	defer api.Close()
+
+	st := submit(t, api.URL, fmt.Sprintf(`{"sites": [%q], "delay": "0s"}`, site.URL))
+	if st.ID != "1" || st.State != server.Running {
+		t.Errorf("unexpected status: %#v", st)
+	}
+
+	// Events are streamed until the job is done.
+	resp, err := http.Get(api.URL + "/jobs/1/events")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if c := resp.Header.Get("Content-Type"); c != "text/event-stream" {
+		t.Errorf("unexpected content type: %s", c)
+	}
+	var events []string
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		if strings.HasPrefix(sc.Text(), "event: ") {
+			events = append(events, strings.TrimPrefix(sc.Text(), "event: "))
+		}
+	}
+	if strings.Join(events, ",") != "result,error,done" && strings.Join(events, ",") != "error,result,done" {
+		t.Errorf("unexpected events: %v", events)
+	}
+
+	code, body := get(t, api.URL+"/jobs/1")
+	noErr(t, json.Unmarshal([]byte(body), &st))
+	if code != http.StatusOK || st.State != server.Done || st.Finished == nil {
+		t.Errorf("unexpected status: %d %s", code, body)
+	}
+	if st.Progress.Pages != 3 || st.Progress.Found != 1 || st.Progress.Errors != 1 {
+		t.Errorf("unexpected progress: %#v", st.Progress)
+	}
+
+	upgrade := strings.Replace(tlsServer.URL, "https", "http", 1) + "/page"
+	tt := []struct{ format, contains string }{
+		{"", site.URL + " " + upgrade},
+		{"json", `"upgrades":[{"page":"` + site.URL + `","url":"` + upgrade + `"}]`},
+		{"csv", "upgradable," + upgrade + "," + site.URL},
+	}
+	for _, tc := range tt {
+		code, body := get(t, api.URL+"/jobs/1/report?format="+tc.format)
+		if code != http.StatusOK || !strings.Contains(body, tc.contains) {
+			t.Errorf("format %s: expected %s; got %d %s", tc.format, tc.contains, code, body)
+		}
+	}
+
+	code, body = get(t, api.URL+"/jobs")
+	if code != http.StatusOK || !strings.Contains(body, `"id":"1"`) {
+		t.Errorf("unexpected list: %d %s", code, body)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	// Each page links to a new page, which never ends without cancellation.
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `<a href="%s-next">next</a>`, r.URL.Path)
+	}))
+	defer site.Close()
+
+	s := &server.Server{}
+	api := httptest.NewServer(s)
+	defer api.Close()
+
+	submit(t, api.URL, fmt.Sprintf(`{"sites": [%q], "delay": "10ms"}`, site.URL+"/page"))
+	submit(t, api.URL, fmt.Sprintf(`{"sites": [%q], "delay": "10ms"}`, site.URL+"/other"))
+
+	req, err := http.NewRequest(http.MethodDelete, api.URL+"/jobs/1", nil)
+	noErr(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	noErr(t, err)
+	resp.Body.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		_, body := get(t, api.URL+"/jobs/1")
+		if strings.Contains(body, `"state":"canceled"`) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("job has not been canceled: %s", body)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Other jobs are not affected.
+	_, body := get(t, api.URL+"/jobs/2")
+	if !strings.Contains(body, `"state":"running"`) {
+		t.Errorf("expected second job to be running: %s", body)
+	}
+	s.Cancel()
+	s.Wait()
+}
+
+func TestErrors(t *testing.T) {
+	api := httptest.NewServer(&server.Server{})
+	defer api.Close()
+
+	tt := []struct {
+		method, path, body string
+		code               int
+		contains           string
+	}{
+		{"POST", "/jobs", `{"sites": []}`, http.StatusBadRequest, "invalid job: no sites given"},
+		{"POST", "/jobs", `{"sites": ["https://example.com"], "dpeth": 1}`, http.StatusBadRequest, "unknown field"},
+		{"POST", "/jobs", `{"sites": ["https://example.com"], "auth": [{"host": "example.com", "cookies": "/etc/passwd"}]}`, http.StatusBadRequest, "cookie files cannot be used via the API"},
+		{"GET", "/jobs/42", "", http.StatusNotFound, "job not found"},
+		{"PUT", "/jobs", "", http.StatusMethodNotAllowed, "method not allowed"},
+		{"GET", "/other", "", http.StatusNotFound, ""},
+	}
+	for _, tc := range tt {
+		req, err := http.NewRequest(tc.method, api.URL+tc.path, strings.NewReader(tc.body))
+		noErr(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		noErr(t, err)
+		b, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.code || !strings.Contains(string(b), tc.contains) {
+			t.Errorf("%s %s: expected %d %s; got %d %s", tc.method, tc.path, tc.code, tc.contains, resp.StatusCode, b)
+		}
+	}
+}
+
+func TestNotifiers(t *testing.T) {
+	api := httptest.NewServer(&server.Server{Notifiers: []string{"slack=https://hooks.slack.com/services/T/B/X"}})
+	defer api.Close()
+
+	resp, err := http.Post(api.URL+"/jobs", "application/json", strings.NewReader(`{"sites": ["http://localhost:1"], "notify": ["webhook=http://169.254.169.254/latest"]}`))
+	noErr(t, err)
+	b, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest || !strings.Contains(string(b), "notifier 'webhook=http://169.254.169.254/latest' is not configured on the server") {
+		t.Errorf("expected unknown notifier to be rejected; got %d %s", resp.StatusCode, b)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer site.Close()
+
+	s := &server.Server{KeepJobs: 2}
+	api := httptest.NewServer(s)
+	defer api.Close()
+
+	for i := 0; i < 4; i++ {
+		submit(t, api.URL, fmt.Sprintf(`{"sites": [%q], "delay": "0s"}`, site.URL))
+		s.Wait()
+	}
+
+	// Only the newest finished jobs are kept and IDs are not reused.
+	_, body := get(t, api.URL+"/jobs")
+	var statuses []server.Status
+	noErr(t, json.Unmarshal([]byte(body), &statuses))
+	var ids []string
+	for _, st := range statuses {
+		ids = append(ids, st.ID)
+	}
+	if strings.Join(ids, ",") != "3,4" {
+		t.Errorf("expected jobs 3 and 4 to be kept; got %v", ids)
+	}
+	if code, _ := get(t, api.URL+"/jobs/1"); code != http.StatusNotFound {
+		t.Errorf("expected job 1 to be removed; got status %d", code)
+	}
+}
+
+func noErr(t *testing.T, err error) {
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
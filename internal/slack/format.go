@@ -1,31 +1,187 @@
 package slack
 
 import (
+	"fmt"
 	"strings"
+
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/slackhook"
 )
 
-// Format Slack message from provided output and error strings.
-func Format(output, errs string) string {
-	var result string
+const (
+	// Number of hosts listed in the summary.
+	topHosts = 5
+	// Slack does not document a hard limit on the total message size,
+	// but messages above this are truncated in practice.
+	maxMessageLength = 40000
+	// Slack rejects messages with more attachments.
+	maxAttachments = 20
+)
 
-	lines := strings.Split(output, "\n")
-	for _, l := range lines {
-		if strings.TrimSpace(l) == "" {
-			continue
+// Messages formats a report as one or more Slack messages using Block Kit.
+// The first message contains a summary with counts per category, the top hosts
+// and, if reportURL is set, a link to the full report.
+// Details follow in the same message and are split into further messages
+// when they exceed Slack's limits.
+func Messages(r report.Report, reportURL string) []slackhook.Data {
+	summary := []slackhook.Block{
+		slackhook.Header(r.Title()),
+	}
+
+	var fields []string
+	for _, c := range r.Categories() {
+		fields = append(fields, fmt.Sprintf("*%s*\n%d", c.Name, c.Count))
+	}
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > slackhook.MaxFields {
+			n = slackhook.MaxFields
+		}
+		summary = append(summary, slackhook.Fields(fields[:n]...))
+		fields = fields[n:]
+	}
+
+	if hosts := r.TopHosts(topHosts); len(hosts) > 0 {
+		s := "*Top hosts*"
+		for _, h := range hosts {
+			s += fmt.Sprintf("\n• %s (%d)", escape(h.Host), h.Count)
+		}
+		summary = append(summary, slackhook.Section(s))
+	}
+
+	if reportURL != "" {
+		summary = append(summary, slackhook.Section(fmt.Sprintf("<%s|View full report>", reportURL)))
+	}
+
+	p := packer{}
+	p.messages = []slackhook.Data{{Text: r.Title(), Blocks: summary}}
+	p.size = length(summary)
+
+	if len(r.Upgrades) > 0 || len(r.Other) > 0 {
+		p.addBlock(slackhook.Divider())
+	}
+	var lines []string
+	for _, l := range r.Upgrades {
+		lines = append(lines, fmt.Sprintf("• %s on page %s", escape(l.URL), escape(l.Page)))
+	}
+	for _, l := range r.Other {
+		lines = append(lines, "• "+escape(l))
+	}
+	for _, c := range chunk("*You can change these links to https*", lines) {
+		p.addBlock(slackhook.Section(c))
+	}
+
+	p.addErrors("Broken links", "danger", r.Broken)
+	p.addErrors("Unreachable links", "warning", r.Unreachable)
+	var errs []string
+	for _, e := range r.Errors {
+		errs = append(errs, escape(e))
+	}
+	for _, c := range chunk("", errs) {
+		p.addAttachment(slackhook.Attachment{Color: "danger", Title: "Other errors", Fallback: "Other errors", Text: c})
+	}
+
+	if n := len(p.messages); n > 1 {
+		for i := range p.messages {
+			p.messages[i].Text = fmt.Sprintf("%s (%d/%d)", r.Title(), i+1, n)
 		}
-		if parts := strings.Split(l, " "); len(parts) == 2 {
-			result += "You can change " + parts[1] + " on page " + parts[0] + " to https.\n"
+	}
+
+	return p.messages
+}
+
+// Packs blocks and attachments into as few messages as possible.
+type packer struct {
+	messages []slackhook.Data
+	size     int
+}
+
+func (p *packer) current() *slackhook.Data {
+	return &p.messages[len(p.messages)-1]
+}
+
+func (p *packer) next() {
+	p.messages = append(p.messages, slackhook.Data{
+		Blocks: []slackhook.Block{slackhook.Context("_continued_")},
+	})
+	p.size = 0
+}
+
+func (p *packer) addBlock(b slackhook.Block) {
+	s := length([]slackhook.Block{b})
+	m := p.current()
+	if len(m.Blocks) >= slackhook.MaxBlocks || len(m.Attachments) > 0 || p.size+s > maxMessageLength {
+		p.next()
+		m = p.current()
+	}
+	m.Blocks = append(m.Blocks, b)
+	p.size += s
+}
+
+func (p *packer) addAttachment(a slackhook.Attachment) {
+	s := len(a.Title) + len(a.Text)
+	m := p.current()
+	if len(m.Attachments) >= maxAttachments || p.size+s > maxMessageLength {
+		p.next()
+		m = p.current()
+	}
+	m.Attachments = append(m.Attachments, a)
+	p.size += s
+}
+
+func (p *packer) addErrors(name, color string, links []report.Link) {
+	var lines []string
+	for _, l := range links {
+		if l.Page == "" {
+			lines = append(lines, "• "+escape(l.URL))
 		} else {
-			result += strings.TrimSpace(l) + "\n"
+			lines = append(lines, fmt.Sprintf("• %s on page %s", escape(l.URL), escape(l.Page)))
 		}
 	}
+	for _, c := range chunk("", lines) {
+		p.addAttachment(slackhook.Attachment{Color: color, Title: name, Fallback: name, Text: c})
+	}
+}
+
+// Split lines into texts that fit into a single section.
+// The heading is prepended to the first text.
+func chunk(heading string, lines []string) []string {
+	if len(lines) == 0 {
+		return nil
+	}
+	var chunks []string
+	c := heading
+	for _, l := range lines {
+		l = slackhook.Truncate(l, slackhook.MaxTextLength)
+		if c != "" && len(c)+1+len(l) > slackhook.MaxTextLength {
+			chunks = append(chunks, c)
+			c = ""
+		}
+		if c != "" {
+			c += "\n"
+		}
+		c += l
+	}
+	return append(chunks, c)
+}
 
-	if strings.TrimSpace(errs) != "" {
-		if result != "" {
-			result += "\n"
+func length(blocks []slackhook.Block) int {
+	n := 0
+	for _, b := range blocks {
+		if b.Text != nil {
+			n += len(b.Text.Text)
+		}
+		for _, f := range b.Fields {
+			n += len(f.Text)
+		}
+		for _, e := range b.Elements {
+			n += len(e.Text)
 		}
-		result += "Errors:\n" + errs + "\n"
 	}
+	return n
+}
 
-	return result
+// Escape control characters used by Slack's mrkdwn format.
+func escape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
 }
@@ -1,71 +1,87 @@
 package slack_test
 
 import (
+	"fmt"
+	"strings"
 	"testing"
 
+	"qvl.io/httpsyet/internal/report"
 	"qvl.io/httpsyet/internal/slack"
+	"qvl.io/httpsyet/slackhook"
 )
 
-func TestFormat(t *testing.T) {
-	tt := []struct{ name, out, err, result string }{
-		{
-			name: "empty",
+func TestMessages(t *testing.T) {
+	r := report.Report{
+		Upgrades: []report.Link{
+			{Page: "https://domain.com", URL: "http://external.com"},
+			{Page: "https://domain.com", URL: "http://external.com/<sub>"},
 		},
-		{
-			name: "full",
-			out: `https://domain.com https://external.com
-https://domain.com https://external.com/sub
-https://site.com https://external.com/page`,
-			err: `failed to get http://expired.com
-404 https://notfound.com`,
-			result: `You can change https://external.com on page https://domain.com to https.
-You can change https://external.com/sub on page https://domain.com to https.
-You can change https://external.com/page on page https://site.com to https.
+		Broken: []report.Link{{Page: "https://domain.com", URL: "https://domain.com/404"}},
+	}
 
-Errors:
-failed to get http://expired.com
-404 https://notfound.com
-`,
-		},
-		{
-			name: "no errors",
-			out: `https://domain.com https://external.com
-https://site.com https://external.com/page`,
-			result: `You can change https://external.com on page https://domain.com to https.
-You can change https://external.com/page on page https://site.com to https.
-`,
-		},
-		{
-			name: "wrong line formats",
-			out: `this-is-an-invalid-format
-this is also invalid`,
-			result: `this-is-an-invalid-format
-this is also invalid
-`,
-		},
-		{
-			name: "empty lines",
-			out: `
+	msgs := slack.Messages(r, "https://reports.com/latest")
+	if len(msgs) != 1 {
+		t.Fatalf("expected one message; got %d", len(msgs))
+	}
+	m := msgs[0]
+	if m.Text != "2 links can be updated to HTTPS" {
+		t.Errorf("unexpected text: %s", m.Text)
+	}
 
-			`,
-			err: `
-			`,
-			result: "",
-		},
-		{
-			name: "errors only",
-			err:  "fail",
-			result: `Errors:
-fail
-`,
-		},
+	var texts []string
+	for _, b := range m.Blocks {
+		if b.Text != nil {
+			texts = append(texts, b.Text.Text)
+		}
+	}
+	expect := []string{
+		"2 links can be updated to HTTPS",
+		"*Top hosts*\n• external.com (2)",
+		"<https://reports.com/latest|View full report>",
+		"*You can change these links to https*\n• http://external.com on page https://domain.com\n• http://external.com/&lt;sub&gt; on page https://domain.com",
+	}
+	if strings.Join(texts, "\n---\n") != strings.Join(expect, "\n---\n") {
+		t.Errorf("expected blocks:\n%s\ngot:\n%s", strings.Join(expect, "\n---\n"), strings.Join(texts, "\n---\n"))
 	}
 
-	for _, tc := range tt {
-		t.Run(tc.name, func(t *testing.T) {
-			if r := slack.Format(tc.out, tc.err); r != tc.result {
-				t.Errorf("expected:\n'%s'\n\ngot:\n'%s'", tc.result, r)
-			}
+	if len(m.Attachments) != 1 || m.Attachments[0].Title != "Broken links" {
+		t.Fatalf("expected one attachment for broken links; got %#v", m.Attachments)
+	}
+}
+
+func TestMessagesChunked(t *testing.T) {
+	var r report.Report
+	for i := 0; i < 5000; i++ {
+		r.Upgrades = append(r.Upgrades, report.Link{
+			Page: "https://domain.com",
+			URL:  fmt.Sprintf("http://external.com/page-%d", i),
 		})
 	}
+
+	msgs := slack.Messages(r, "")
+	if len(msgs) < 2 {
+		t.Fatalf("expected multiple messages; got %d", len(msgs))
+	}
+
+	lines := 0
+	for i, m := range msgs {
+		if e := fmt.Sprintf("5000 links can be updated to HTTPS (%d/%d)", i+1, len(msgs)); m.Text != e {
+			t.Errorf("expected text %s; got %s", e, m.Text)
+		}
+		if len(m.Blocks) > slackhook.MaxBlocks {
+			t.Errorf("message %d has %d blocks", i, len(m.Blocks))
+		}
+		for _, b := range m.Blocks {
+			if b.Text == nil {
+				continue
+			}
+			if len(b.Text.Text) > slackhook.MaxTextLength {
+				t.Errorf("message %d has text of length %d", i, len(b.Text.Text))
+			}
+			lines += strings.Count(b.Text.Text, "• http://external.com/")
+		}
+	}
+	if lines != 5000 {
+		t.Errorf("expected all 5000 links to be included; got %d", lines)
+	}
 }
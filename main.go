@@ -3,18 +3,26 @@
 package main
 
 import (
-	"bytes"
+	"context"
 	"flag"
 	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"log/slog"
 	"os"
 	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
 	"time"
 
 	"qvl.io/httpsyet/httpsyet"
-	"qvl.io/httpsyet/internal/slack"
-	"qvl.io/httpsyet/slackhook"
+	"qvl.io/httpsyet/internal/config"
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/progress"
+	"qvl.io/httpsyet/internal/report"
+	"qvl.io/httpsyet/internal/runner"
 )
 
 // Can be set in build step using -ldflags
@@ -25,6 +33,10 @@ const (
 	usage = `Find links you can update to HTTPS
 
 Usage: %s [flags] url...
+       %s [flags] -config file
+       %s validate file
+       %s serve [flags]
+       %s daemon [flags] -config file
 
   url  one or more URLs you like to be crawled
 
@@ -45,6 +57,12 @@ Errors are reported on stderr.
 
 'httpsyet -parallel 5 -delay 1s' means that you will have max 5 requests per second.
 
+Multiple jobs with different settings can be defined in a JSON file passed via -config.
+Flags set on the command line override the values of all jobs in the file.
+'httpsyet validate file' checks a config file and reports all errors.
+'httpsyet serve' runs an HTTP API to submit jobs. See 'httpsyet serve -help'.
+'httpsyet daemon' runs jobs on schedules defined in the config file. See 'httpsyet daemon -help'.
+
 Flags:
 `
 	more = "\nFor more visit https://qvl.io/httpsyet."
@@ -52,17 +70,73 @@ Flags:
 
 // Get command line arguments and start crawling
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "validate":
+			validate(os.Args[2:])
+			return
+		case "serve":
+			serve(os.Args[2:])
+			return
+		case "daemon":
+			daemon(os.Args[2:])
+			return
+		}
+	}
+
 	// Flags
-	slackURL := flag.String("slack", "", "Slack incoming webhook. If set, results are also posted to Slack. See https://api.slack.com/incoming-webhooks.")
+	configFile := flag.String("config", "", "JSON file defining one or more jobs. See 'httpsyet validate'.")
+	var jobNames listFlag
+	flag.Var(&jobNames, "job", "Only run the job with this name from -config. Can be repeated.")
+	var notifySpecs listFlag
+	flag.Var(&notifySpecs, "notify", "Send results to a chat service or webhook. Format is kind=url where kind is one of "+strings.Join(notify.Kinds, ", ")+". Can be repeated.")
+	slackURL := flag.String("slack", "", "Slack incoming webhook. If set, results are also posted to Slack. Same as -notify slack=url. See https://api.slack.com/incoming-webhooks.")
+	reportURL := flag.String("report-url", "", "URL of the full report. If set, it is linked in notifications.")
+	smtpHost := flag.String("smtp-host", os.Getenv("HTTPSYET_SMTP_HOST"), "SMTP server for sending results via email. Defaults to $HTTPSYET_SMTP_HOST.")
+	smtpPort := flag.Int("smtp-port", envInt("HTTPSYET_SMTP_PORT", 587), "SMTP server port. Defaults to $HTTPSYET_SMTP_PORT or 587.")
+	smtpStartTLS := flag.Bool("smtp-starttls", os.Getenv("HTTPSYET_SMTP_STARTTLS") != "false", "Use STARTTLS for SMTP. Disable with -smtp-starttls=false or HTTPSYET_SMTP_STARTTLS=false.")
+	smtpUser := flag.String("smtp-user", os.Getenv("HTTPSYET_SMTP_USER"), "SMTP username. Defaults to $HTTPSYET_SMTP_USER.")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password. Prefer setting $HTTPSYET_SMTP_PASSWORD, which is used if the flag is empty.")
+	smtpFrom := flag.String("smtp-from", os.Getenv("HTTPSYET_SMTP_FROM"), "Sender address of emails. Defaults to $HTTPSYET_SMTP_FROM.")
+	smtpTo := flag.String("smtp-to", os.Getenv("HTTPSYET_SMTP_TO"), "Comma separated list of email recipients. If set, results are sent via email. Defaults to $HTTPSYET_SMTP_TO.")
+	issuesURL := flag.String("issues", "", "Open an issue per site listing links to update and close it once fixed. Format is kind=url where kind is github or gitlab and url is the API URL of the repository, e.g. github=https://api.github.com/repos/owner/repo.")
+	issuesToken := flag.String("issues-token", "", "Token for the issue tracker. Prefer setting $HTTPSYET_ISSUES_TOKEN, which is used if the flag is empty.")
+	issuesGroup := flag.String("issues-group", notify.GroupBySite, "Open one issue per crawled site or per external host. One of site, host.")
+	issuesLabel := flag.String("issues-label", "httpsyet", "Label of issues opened with -issues.")
+	webhookTemplate := flag.String("webhook-template", "", "File containing a Go text/template for the body of -notify webhook=url. Executed with the report. Defaults to the report as JSON.")
 	depth := flag.Int("depth", 0, "Set to >=1 to specify how many layers of pages to crawl.")
 	parallel := flag.Int("parallel", 10, "Value needs to be >= 1. Specify how many parallel requests are made per domain.")
 	delay := flag.Duration("delay", time.Second, "Delay between requests.")
+	probe := flag.String("probe", httpsyet.ProbeHead, "How external links are checked. One of head (falls back to get if not supported), get.")
+	broken := flag.Bool("broken", false, "Check for broken links. Writes categorized findings as JSON lines instead of links to update: dns, connection_refused, timeout, tls, 4xx, 5xx, soft_404, missing_fragment, bare_domain, protocol_relative and other.")
+	robots := flag.Bool("robots", false, "Don't crawl pages linked with rel=\"nofollow\" or from pages with a robots meta tag containing nofollow. Such links are still checked.")
+	scope := flag.String("scope", "", "Pages crawled recursively. One of host (only the host of each site), domain (all hosts of the registrable domain like www.example.com and docs.example.com). Defaults to host.")
+	var scopeHosts listFlag
+	flag.Var(&scopeHosts, "scope-host", "Additional host crawled recursively. Use a leading *. to include subdomains. Can be repeated.")
+	scopePrefix := flag.String("scope-prefix", "", "Only crawl pages whose path starts with this prefix recursively, e.g. /docs/.")
+	suffixList := flag.String("public-suffix-list", "", "File in the format of public_suffix_list.dat used for -scope domain. Defaults to the list installed on the system.")
+	maxPages := flag.Int64("max-pages", 0, "Stop after requesting this many pages and links of all sites together. Pages left in the queue are reported as unexplored. 0 means no limit.")
+	maxBytes := flag.Int64("max-bytes", 0, "Stop after downloading this many bytes of all sites together. 0 means no limit.")
+	maxPageSize := flag.Int64("max-page-size", 0, "Only read this many bytes of each page. Larger pages are truncated. 0 means no limit.")
+	maxDuration := flag.Duration("max-duration", 0, "Stop crawling after this time. 0 means no limit.")
+	siteMaxPages := flag.Int64("site-max-pages", 0, "Like -max-pages but for each site.")
+	siteMaxBytes := flag.Int64("site-max-bytes", 0, "Like -max-bytes but for each site.")
+	siteMaxDuration := flag.Duration("site-max-duration", 0, "Like -max-duration but for each site.")
+	unexploredFile := flag.String("unexplored", "", "Write pages not crawled because a budget has been exhausted to this file, one URL per line. Otherwise only their number is printed.")
+	traps := flag.Bool("traps", false, "Detect crawler traps like endless calendars, repeating paths and session IDs in URLs and stop crawling them. Reported as crawler_trap with -broken.")
+	retries := flag.Int("retries", 0, "Retry requests failing with timeouts, dropped connections or status codes like 503 this many times.")
+	backoff := flag.Duration("retry-backoff", time.Second, "Wait before the first retry. Doubled for each further retry. Retry-After headers are honored.")
+	metricsFile := flag.String("metrics-file", "", "After crawling, write metrics in the Prometheus text format to this file, e.g. for the textfile collector of the node exporter.")
 	versionFlag := flag.Bool("version", false, "Print binary version.")
 	verbose := flag.Bool("verbose", false, "Output status updates to standard error.")
+	logFormat := flag.String("log-format", "", "Write structured logs to standard error. One of text, json. Defaults to plain lines.")
+	fetch := addFetchFlags(flag.CommandLine)
+	cache := addCacheFlags(flag.CommandLine)
+	showProgress := flag.Bool("progress", false, "Show pages crawled, queue length, active requests, request rate and findings on standard error.")
 
 	// Parse args
 	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, usage, os.Args[0])
+		fmt.Fprintf(os.Stderr, usage, os.Args[0], os.Args[0], os.Args[0], os.Args[0], os.Args[0])
 		flag.PrintDefaults()
 		fmt.Fprintln(os.Stderr, more)
 	}
@@ -73,43 +147,325 @@ func main() {
 		os.Exit(0)
 	}
 
-	sites := flag.Args()
-	if len(sites) == 0 {
-		flag.Usage()
-		os.Exit(1)
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+	// Secrets are not used as flag defaults so they are never printed by -help.
+	if *smtpPassword == "" {
+		*smtpPassword = os.Getenv("HTTPSYET_SMTP_PASSWORD")
+	}
+	if *issuesToken == "" {
+		*issuesToken = os.Getenv("HTTPSYET_ISSUES_TOKEN")
 	}
 
-	var output io.Writer = os.Stdout
-	var errWriter io.Writer = os.Stderr
-	var slackBuf, slackErrBuf bytes.Buffer
 	if *slackURL != "" {
-		output = io.MultiWriter(output, &slackBuf)
-		errWriter = io.MultiWriter(errWriter, &slackErrBuf)
-	}
-	errs := log.New(errWriter, "", 0)
-
-	err := httpsyet.Crawler{
-		Sites:    sites,
-		Out:      output,
-		Log:      errs,
-		Depth:    *depth,
-		Parallel: *parallel,
-		Delay:    *delay,
+		notifySpecs = append(notifySpecs, "slack="+*slackURL)
+	}
+
+	// Jobs either come from the config file or from the command line.
+	var jobs []config.Job
+	if *configFile != "" {
+		if flag.NArg() > 0 {
+			fmt.Fprintln(os.Stderr, "cannot use url arguments together with -config")
+			os.Exit(1)
+		}
+		c, err := config.Load(*configFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		jobs, err = selectJobs(c.Jobs, jobNames)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		if flag.NArg() == 0 {
+			flag.Usage()
+			os.Exit(1)
+		}
+		jobs = []config.Job{{Sites: flag.Args()}}
+	}
+
+	// Flags override values from the config file.
+	for i := range jobs {
+		j := &jobs[i]
+		if set["depth"] || *configFile == "" {
+			j.Depth = *depth
+		}
+		if set["parallel"] || *configFile == "" {
+			j.Parallel = *parallel
+		}
+		if set["delay"] || j.Delay == "" {
+			j.Delay = delay.String()
+		}
+		if len(notifySpecs) > 0 {
+			j.Notify = notifySpecs
+		}
+		if *reportURL != "" {
+			j.ReportURL = *reportURL
+		}
+		if set["broken"] {
+			j.Broken = *broken
+		}
+		if set["robots"] {
+			j.Robots = *robots
+		}
+		if set["scope"] || set["scope-host"] || set["scope-prefix"] {
+			j.Scope = &config.Scope{Mode: *scope, Hosts: scopeHosts, Prefix: *scopePrefix}
+		}
+		if set["max-pages"] || set["max-bytes"] || set["max-page-size"] || set["max-duration"] {
+			j.Budget = &config.Budget{Pages: *maxPages, Bytes: *maxBytes, PageSize: *maxPageSize, Duration: maxDuration.String()}
+		}
+		if set["site-max-pages"] || set["site-max-bytes"] || set["site-max-duration"] {
+			j.SiteBudget = &config.Budget{Pages: *siteMaxPages, Bytes: *siteMaxBytes, Duration: siteMaxDuration.String()}
+		}
+		if set["traps"] && !*traps {
+			j.Traps = nil
+		} else if *traps && j.Traps == nil {
+			j.Traps = &config.Traps{}
+		}
+		if set["retries"] || *configFile == "" {
+			j.Retries = *retries
+		}
+		if set["retry-backoff"] || j.Backoff == "" {
+			j.Backoff = backoff.String()
+		}
+		if set["probe"] || j.Probe == "" {
+			j.Probe = *probe
+		}
+	}
+
+	var tmpl *template.Template
+	if *webhookTemplate != "" {
+		text, err := ioutil.ReadFile(*webhookTemplate)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to read webhook template: %v\n", err)
+			os.Exit(1)
+		}
+		tmpl, err = notify.ParseTemplate(*webhookTemplate, string(text))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid webhook template: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	// Notifiers configured only via flags apply to all jobs.
+	var global []notify.Notifier
+	if *smtpTo != "" {
+		global = append(global, notify.Email{
+			Host:      *smtpHost,
+			Port:      *smtpPort,
+			StartTLS:  *smtpStartTLS,
+			Username:  *smtpUser,
+			Password:  *smtpPassword,
+			From:      *smtpFrom,
+			To:        splitList(*smtpTo),
+			ReportURL: *reportURL,
+		})
+	}
+	if *issuesURL != "" {
+		i := strings.Index(*issuesURL, "=")
+		if i < 1 {
+			fmt.Fprintf(os.Stderr, "invalid issue tracker '%s': expected format kind=url\n", *issuesURL)
+			os.Exit(1)
+		}
+		is := notify.Issues{
+			Kind:    (*issuesURL)[:i],
+			BaseURL: (*issuesURL)[i+1:],
+			Token:   *issuesToken,
+			Label:   *issuesLabel,
+			Group:   *issuesGroup,
+		}
+		if err := is.Validate(); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		global = append(global, is)
+	}
+
+	r := runner.Runner{
+		Out:      os.Stdout,
+		Err:      os.Stderr,
+		Notify:   global,
+		Template: tmpl,
 		Verbose:  *verbose,
-	}.Run()
+	}
+	var err error
+	if r.Logger, err = newLogger(*logFormat, *verbose); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if r.Fetcher, err = fetch.fetcher(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if r.Cache, r.Pages, err = cache.load(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fetch.close()
+		os.Exit(1)
+	}
+	if *suffixList != "" {
+		if r.Suffixes, err = httpsyet.LoadPublicSuffixList(*suffixList); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			fetch.close()
+			os.Exit(1)
+		}
+	}
+	if *metricsFile != "" {
+		r.Metrics = &metrics.Registry{}
+	}
+	failed := false
+	var unexplored []string
+	for _, j := range jobs {
+		rep, err := runJob(r, j, *showProgress)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+		unexplored = append(unexplored, rep.Unexplored...)
+	}
+	if err := reportUnexplored(os.Stderr, *unexploredFile, unexplored); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+	if err := cache.save(r.Cache, r.Pages); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+	if r.Metrics != nil {
+		if err := r.Metrics.WriteFile(*metricsFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	fetch.close()
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// Returns a structured logger writing to standard error or nil for plain log lines.
+// Debug records are only written if verbose is set.
+func newLogger(format string, verbose bool) (*slog.Logger, error) {
+	level := slog.LevelInfo
+	if verbose {
+		level = slog.LevelDebug
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "":
+		return nil, nil
+	case "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	}
+	return nil, fmt.Errorf("invalid log format '%s': expected text or json", format)
+}
+
+// Run a single job and optionally show its progress.
+func runJob(r runner.Runner, j config.Job, showProgress bool) (report.Report, error) {
+	if !showProgress {
+		return r.Run(context.Background(), j, nil)
+	}
+
+	term := &progress.Terminal{Out: os.Stderr, TTY: progress.IsTerminal(os.Stderr)}
+	r.Err = term
+	var p httpsyet.Progress
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		term.Watch(ctx, &p)
+		close(done)
+	}()
+
+	rep, err := r.Run(context.Background(), j, &p)
+	cancel()
+	<-done
+	return rep, err
+}
+
+// Tell how many pages have not been crawled because a budget has been exhausted
+// and list them in file if set.
+func reportUnexplored(w io.Writer, file string, urls []string) error {
+	if len(urls) == 0 {
+		return nil
+	}
+	if file == "" {
+		fmt.Fprintf(w, "%d pages not crawled because a budget has been exhausted; list them with -unexplored file\n", len(urls))
+		return nil
+	}
+	if err := ioutil.WriteFile(file, []byte(strings.Join(urls, "\n")+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write unexplored pages: %v", err)
+	}
+	fmt.Fprintf(w, "%d pages not crawled because a budget has been exhausted; listed in %s\n", len(urls), file)
+	return nil
+}
 
+// Check a config file and report all errors.
+func validate(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s validate file\n", os.Args[0])
+		os.Exit(1)
+	}
+	c, err := config.Load(args[0])
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to crawl: %v", err)
+		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
+	fmt.Printf("%s is valid (jobs: %d)\n", args[0], len(c.Jobs))
+}
 
-	if *slackURL == "" {
-		return
+// Filter jobs by name.
+// Returns all jobs if no names are given.
+func selectJobs(jobs []config.Job, names []string) ([]config.Job, error) {
+	if len(names) == 0 {
+		return jobs, nil
 	}
+	var selected []config.Job
+	for _, n := range names {
+		found := false
+		for _, j := range jobs {
+			if j.Name == n {
+				selected = append(selected, j)
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown job '%s'", n)
+		}
+	}
+	return selected, nil
+}
 
-	msg := slack.Format(slackBuf.String(), slackErrBuf.String())
-	if err := slackhook.Post(*slackURL, msg); err != nil {
-		errs.Printf("failed posting to Slack: %v", err)
-		os.Exit(1)
+// Split a comma separated list and trim whitespace.
+func splitList(s string) []string {
+	var l []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
 	}
+	return l
+}
+
+// Read an integer from the environment, falling back to def if unset or invalid.
+func envInt(name string, def int) int {
+	if i, err := strconv.Atoi(os.Getenv(name)); err == nil {
+		return i
+	}
+	return def
+}
+
+// Collects values of a flag that can be passed multiple times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *listFlag) Set(s string) error {
+	*l = append(*l, s)
+	return nil
 }
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestReportUnexplored(t *testing.T) {
+	var out bytes.Buffer
+	if err := reportUnexplored(&out, "", nil); err != nil || out.Len() != 0 {
+		t.Errorf("expected no output without unexplored pages; got %q, %v", out.String(), err)
+	}
+
+	urls := []string{"https://example.com/a", "https://example.com/b"}
+	if err := reportUnexplored(&out, "", urls); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := "2 pages not crawled because a budget has been exhausted; list them with -unexplored file\n"; out.String() != e {
+		t.Errorf("expected %q; got %q", e, out.String())
+	}
+
+	out.Reset()
+	file := filepath.Join(t.TempDir(), "unexplored.txt")
+	if err := reportUnexplored(&out, file, urls); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := "2 pages not crawled because a budget has been exhausted; listed in " + file + "\n"; out.String() != e {
+		t.Errorf("expected %q; got %q", e, out.String())
+	}
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if e := "https://example.com/a\nhttps://example.com/b\n"; string(b) != e {
+		t.Errorf("expected file to list unexplored pages; got %q", b)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"qvl.io/httpsyet/internal/metrics"
+	"qvl.io/httpsyet/internal/notify"
+	"qvl.io/httpsyet/internal/server"
+)
+
+const serveUsage = `Run an HTTP API to submit and monitor crawl jobs
+
+Usage: %s serve [flags]
+
+Endpoints:
+  POST   /jobs              Submit a job. The body is a job as in the config file.
+  GET    /jobs              List all jobs.
+  GET    /jobs/{id}         Get status and progress of a job.
+  DELETE /jobs/{id}         Cancel a job.
+  GET    /jobs/{id}/events  Stream results as Server-Sent Events.
+  GET    /jobs/{id}/report  Get the report. Use ?format=text, json or csv.
+  GET    /metrics           Prometheus metrics, if enabled with -metrics.
+
+Flags:
+`
+
+// Run the HTTP API until interrupted.
+func serve(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	addr := fs.String("addr", "localhost:8080", "Address to listen on.")
+	withMetrics := fs.Bool("metrics", false, "Serve metrics in the Prometheus text format at /metrics.")
+	var notifiers listFlag
+	fs.Var(&notifiers, "notify", "Notifier as kind=url that submitted jobs may use in \"notify\". Jobs using other notifiers are rejected. Can be repeated.")
+	keepJobs := fs.Int("keep-jobs", server.DefaultKeepJobs, "Number of finished jobs kept for their status and report.")
+	keepFor := fs.Duration("keep-jobs-for", server.DefaultKeepFor, "Time finished jobs are kept for their status and report.")
+	fetch := addFetchFlags(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, serveUsage, os.Args[0])
+		fs.PrintDefaults()
+	}
+	_ = fs.Parse(args)
+
+	f, err := fetch.fetcher()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	for _, n := range notifiers {
+		if _, err := notify.Parse(n, notify.Options{}); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	s := &server.Server{Fetcher: f, Notifiers: notifiers, KeepJobs: *keepJobs, KeepFor: *keepFor}
+	if *withMetrics {
+		s.Metrics = &metrics.Registry{}
+	}
+	srv := &http.Server{Addr: *addr, Handler: s}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		s.Cancel()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(ctx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "listening on %s\n", *addr)
+	err = srv.ListenAndServe()
+	fetch.close()
+	if err != http.ErrServerClosed {
+		fmt.Fprintf(os.Stderr, "failed to serve: %v\n", err)
+		os.Exit(1)
+	}
+	s.Wait()
+}
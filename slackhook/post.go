@@ -9,14 +9,102 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"unicode/utf8"
 )
 
 // Data is sent to Slack as JSON.
+// If Blocks are set, Text is only used as a fallback for notifications.
 type Data struct {
-	Text      string `json:"text"`
-	Username  string `json:"username,omitempty"`
-	Channel   string `json:"channel,omitempty"`
-	IconEmoji string `json:"icon_emoji,omitempty"`
+	Text        string       `json:"text"`
+	Username    string       `json:"username,omitempty"`
+	Channel     string       `json:"channel,omitempty"`
+	IconEmoji   string       `json:"icon_emoji,omitempty"`
+	Blocks      []Block      `json:"blocks,omitempty"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Block is a Block Kit layout block.
+// For more see https://api.slack.com/reference/block-kit/blocks.
+type Block struct {
+	Type     string `json:"type"`
+	Text     *Text  `json:"text,omitempty"`
+	Fields   []Text `json:"fields,omitempty"`
+	Elements []Text `json:"elements,omitempty"`
+}
+
+// Text is a Block Kit text object.
+type Text struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+}
+
+// Attachment is a secondary message part, displayed with a colored border.
+type Attachment struct {
+	Color     string  `json:"color,omitempty"`
+	Fallback  string  `json:"fallback,omitempty"`
+	Title     string  `json:"title,omitempty"`
+	TitleLink string  `json:"title_link,omitempty"`
+	Text      string  `json:"text,omitempty"`
+	Blocks    []Block `json:"blocks,omitempty"`
+}
+
+// Limits enforced by Slack.
+// Content exceeding them is truncated or rejected.
+const (
+	MaxBlocks     = 50   // Blocks per message.
+	MaxTextLength = 3000 // Characters per section text.
+	MaxFields     = 10   // Fields per section.
+	MaxHeader     = 150  // Characters per header text.
+)
+
+// Markdown creates a text object using Slack's mrkdwn format.
+func Markdown(s string) Text {
+	return Text{Type: "mrkdwn", Text: s}
+}
+
+// Header creates a header block.
+// Text longer than MaxHeader is truncated.
+func Header(s string) Block {
+	return Block{Type: "header", Text: &Text{Type: "plain_text", Text: Truncate(s, MaxHeader)}}
+}
+
+// Section creates a section block with markdown text.
+func Section(s string) Block {
+	t := Markdown(s)
+	return Block{Type: "section", Text: &t}
+}
+
+// Fields creates a section block with markdown text displayed in two columns.
+// Slack rejects sections with more than MaxFields fields.
+func Fields(fields ...string) Block {
+	b := Block{Type: "section"}
+	for _, f := range fields {
+		b.Fields = append(b.Fields, Markdown(f))
+	}
+	return b
+}
+
+// Truncate shortens s to at most max bytes, ending it with "...".
+// Multi-byte characters are never cut in half.
+func Truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	n := max - 3
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+// Context creates a context block with small markdown text.
+func Context(s string) Block {
+	return Block{Type: "context", Elements: []Text{Markdown(s)}}
+}
+
+// Divider creates a divider block.
+func Divider() Block {
+	return Block{Type: "divider"}
 }
 
 // Post a message to a Slack incoming webhook.
@@ -24,6 +112,11 @@ func Post(hook, text string) error {
 	return PostCustom(hook, Data{Text: text}, http.Post)
 }
 
+// PostData posts a message with custom data, such as blocks or attachments, to a Slack incoming webhook.
+func PostData(hook string, d Data) error {
+	return PostCustom(hook, d, http.Post)
+}
+
 // PostCustom posts a message to Slack while allowing to overwrite the webhook defaults and http.Post.
 func PostCustom(hook string, d Data, post func(string, string, io.Reader) (*http.Response, error)) error {
 	buf, err := json.Marshal(d)
@@ -35,6 +128,7 @@ func PostCustom(hook string, d Data, post func(string, string, io.Reader) (*http
 	if err != nil {
 		return fmt.Errorf("failed to post to Slack: %v", err)
 	}
+	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
 		body, err := ioutil.ReadAll(resp.Body)
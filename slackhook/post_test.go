@@ -1,9 +1,11 @@
 package slackhook_test
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"unicode/utf8"
 
 	"qvl.io/httpsyet/slackhook"
 )
@@ -38,3 +40,51 @@ func TestServerErr(t *testing.T) {
 		t.Errorf("expected error to be:\n	%s\ngot:\n	%v", expected, err)
 	}
 }
+
+func TestPostData(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var d slackhook.Data
+		if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if len(d.Blocks) != 2 || d.Blocks[0].Type != "header" || d.Blocks[1].Text.Type != "mrkdwn" {
+			t.Errorf("unexpected blocks: %#v", d.Blocks)
+		}
+		if len(d.Attachments) != 1 || d.Attachments[0].Color != "danger" {
+			t.Errorf("unexpected attachments: %#v", d.Attachments)
+		}
+	}))
+	defer s.Close()
+
+	err := slackhook.PostData(s.URL, slackhook.Data{
+		Text:        text,
+		Blocks:      []slackhook.Block{slackhook.Header("Title"), slackhook.Section(text)},
+		Attachments: []slackhook.Attachment{{Color: "danger", Text: text}},
+	})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s        string
+		max      int
+		expected string
+	}{
+		{"short", 10, "short"},
+		{"exactly10!", 10, "exactly10!"},
+		{"too long text", 10, "too lon..."},
+		{"ääääää", 10, "äää..."}, // 12 bytes, 2 per character.
+		{"aäääää", 10, "aäää..."},
+	}
+	for _, tt := range tests {
+		got := slackhook.Truncate(tt.s, tt.max)
+		if got != tt.expected {
+			t.Errorf("Truncate(%q, %d): expected %q; got %q", tt.s, tt.max, tt.expected, got)
+		}
+		if !utf8.ValidString(got) || len(got) > tt.max {
+			t.Errorf("Truncate(%q, %d): invalid result %q", tt.s, tt.max, got)
+		}
+	}
+}